@@ -66,3 +66,18 @@ func (p *Id) Parse() error {
 	p.Native = datatype.Id(id)
 	return nil
 }
+
+// Parse with the failure attributed to the field
+func (f *Float) ParseField(field string) error {
+	return parseFieldError(field, f.Str, f.Parse())
+}
+
+// Parse with the failure attributed to the field
+func (t *TimeSpan) ParseField(field string) error {
+	return parseFieldError(field, t.Str, t.Parse())
+}
+
+// Parse with the failure attributed to the field
+func (p *Id) ParseField(field string) error {
+	return parseFieldError(field, p.Str, p.Parse())
+}
@@ -0,0 +1,241 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ghthor/database/datatype"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+// The memory used to buffer a multipart body before files are written to disk
+const MaxMultipartMemory = 32 << 20
+
+// The largest JSON body that's decoded
+const MaxJSONBodySize = 1 << 20
+
+var formFileType = reflect.TypeOf(datatype.FormFile{})
+
+// FromRequest binds the request's input to the fields of the action,
+// which must be a pointer to a struct, then checks the action IsValid.
+//
+// Each field is bound to the input named by its `form` tag, or the field's
+// name if it doesn't have one. A tag of "-" skips the field. Input is read
+// from the query parameters and a url encoded or multipart form, or from the
+// query parameters and then the properties of an object if the body is JSON,
+// so a property replaces a query parameter of the same name. A datatype.FormFile
+// field is bound to an uploaded file in a multipart form. Nested structs
+// are bound from a nested JSON object or from form inputs named "Outer.Inner".
+//
+// Input that can't be bound is returned as a *ValidationError before
+// the action is validated.
+//
+// The files bound to the action are open until they're closed with
+// CloseFiles and the temporary files of a multipart form remain until
+// r.MultipartForm.RemoveAll, the caller is responsible for both.
+func FromRequest(r *http.Request, a A) error {
+	if err := Bind(r, a); err != nil {
+		return err
+	}
+	return a.IsValid()
+}
+
+// Bind binds the request's input to the action like FromRequest
+// without checking the action IsValid.
+func Bind(r *http.Request, a A) error {
+	v := reflect.ValueOf(a)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("action: Bind requires a pointer to a struct, got %T", a))
+	}
+
+	verr := &ValidationError{}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		// Only the query parameters are parsed, the properties of the body take precedence
+		if err := r.ParseForm(); err != nil {
+			verr.Add("", "", "form", err.Error())
+			return verr
+		}
+		bindForm(verr, "", v.Elem(), r)
+
+		var body map[string]json.RawMessage
+		if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, MaxJSONBodySize)).Decode(&body); err != nil {
+			verr.Add("", "", "json", err.Error())
+			return verr
+		}
+		bindJSON(verr, "", v.Elem(), body)
+
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(MaxMultipartMemory); err != nil {
+			verr.Add("", "", "form", err.Error())
+			return verr
+		}
+		bindForm(verr, "", v.Elem(), r)
+
+	default:
+		if err := r.ParseForm(); err != nil {
+			verr.Add("", "", "form", err.Error())
+			return verr
+		}
+		bindForm(verr, "", v.Elem(), r)
+	}
+
+	return verr.Err()
+}
+
+func inputName(sf reflect.StructField) string {
+	if name := sf.Tag.Get("form"); name != "" {
+		return name
+	}
+	return sf.Name
+}
+
+func isFieldParser(v reflect.Value) bool {
+	return v.Addr().Type().Implements(fieldParserType)
+}
+
+func bindForm(verr *ValidationError, prefix string, v reflect.Value, r *http.Request) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := inputName(sf)
+		if sf.PkgPath != "" || name == "-" {
+			continue
+		}
+
+		field, name := v.Field(i), prefix+name
+
+		switch {
+		case sf.Type == formFileType:
+			if r.MultipartForm == nil || len(r.MultipartForm.File[name]) == 0 {
+				continue
+			}
+
+			header := r.MultipartForm.File[name][0]
+			file, err := header.Open()
+			if !verr.Check(name, header.Filename, "file", err) {
+				continue
+			}
+			field.Set(reflect.ValueOf(datatype.FormFile{File: file, Header: header, Field: name}))
+
+		case isFieldParser(field):
+			if values, exists := r.Form[name]; exists {
+				field.FieldByName("Str").SetString(values[0])
+			}
+
+		case field.Kind() == reflect.Struct:
+			bindForm(verr, name+".", field, r)
+
+		default:
+			if values, exists := r.Form[name]; exists {
+				verr.Check(name, values[0], "type", setString(field, values[0]))
+			}
+		}
+	}
+}
+
+// Closes every datatype.FormFile of the action, which must be a
+// pointer to a struct, and returns the first error
+func CloseFiles(a A) error {
+	return closeFiles(reflect.ValueOf(a).Elem())
+}
+
+func closeFiles(v reflect.Value) error {
+	var err error
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf, field := t.Field(i), v.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		var fieldErr error
+		switch {
+		case sf.Type == formFileType:
+			fieldErr = field.Interface().(datatype.FormFile).Close()
+		case field.Kind() == reflect.Struct && !isFieldParser(field):
+			fieldErr = closeFiles(field)
+		}
+
+		if err == nil {
+			err = fieldErr
+		}
+	}
+	return err
+}
+
+func bindJSON(verr *ValidationError, prefix string, v reflect.Value, body map[string]json.RawMessage) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := inputName(sf)
+		if sf.PkgPath != "" || name == "-" || sf.Type == formFileType {
+			continue
+		}
+
+		raw, exists := body[name]
+		if !exists {
+			continue
+		}
+
+		field, path := v.Field(i), prefix+name
+
+		switch {
+		case isFieldParser(field):
+			// Helpers accept a string or any other JSON literal such as a number
+			var str string
+			if err := json.Unmarshal(raw, &str); err != nil {
+				str = string(raw)
+			}
+			field.FieldByName("Str").SetString(str)
+
+		case field.Kind() == reflect.Struct:
+			var nested map[string]json.RawMessage
+			if verr.Check(path, string(raw), "type", json.Unmarshal(raw, &nested)) {
+				bindJSON(verr, path+".", field, nested)
+			}
+
+		default:
+			verr.Check(path, string(raw), "type", json.Unmarshal(raw, field.Addr().Interface()))
+		}
+	}
+}
+
+// Converts form input to the field's kind
+func setString(v reflect.Value, str string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(str)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(str, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(str, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(str, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("can't bind to a %s", v.Type())
+	}
+	return nil
+}
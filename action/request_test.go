@@ -0,0 +1,171 @@
+package action
+
+import (
+	"bytes"
+	"errors"
+	"github.com/ghthor/database/datatype"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+type mockUploadAction struct {
+	Id      Id     `form:"id" action:"required"`
+	Price   Float  `form:"price" action:"min=0"`
+	Name    string `form:"name"`
+	Count   int    `form:"count"`
+	Address mockAddress
+	Image   datatype.FormFile `form:"image"`
+	Skipped string            `form:"-"`
+}
+
+func (a *mockUploadAction) IsValid() error { return Validate(a) }
+
+func DescribeFromRequest(c gospec.Context) {
+	c.Specify("an action can be bound", func() {
+		a := &mockUploadAction{}
+
+		c.Specify("from a url encoded form and query params", func() {
+			form := url.Values{
+				"price":       {"1.5"},
+				"name":        {"a name"},
+				"count":       {"3"},
+				"Address.Zip": {"12345"},
+				"Skipped":     {"skipped"},
+			}
+			r := httptest.NewRequest("POST", "/?id=7", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			c.Assume(FromRequest(r, a), IsNil)
+			c.Expect(a.Id.Native, Equals, datatype.Id(7))
+			c.Expect(a.Price.Native, Equals, 1.5)
+			c.Expect(a.Name, Equals, "a name")
+			c.Expect(a.Count, Equals, 3)
+			c.Expect(a.Address.Zip, Equals, "12345")
+			c.Expect(a.Skipped, Equals, "")
+		})
+
+		c.Specify("from a JSON body", func() {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(`{
+				"id": 7,
+				"price": "1.5",
+				"name": "a name",
+				"count": 3,
+				"Address": {"Zip": "12345"}
+			}`))
+			r.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+			c.Assume(FromRequest(r, a), IsNil)
+			c.Expect(a.Id.Native, Equals, datatype.Id(7))
+			c.Expect(a.Price.Native, Equals, 1.5)
+			c.Expect(a.Name, Equals, "a name")
+			c.Expect(a.Count, Equals, 3)
+			c.Expect(a.Address.Zip, Equals, "12345")
+		})
+
+		c.Specify("from a JSON body and query params", func() {
+			r := httptest.NewRequest("POST", "/?id=7&name=query&count=3&Address.Zip=12345", strings.NewReader(`{"name": "a name"}`))
+			r.Header.Set("Content-Type", "application/json")
+
+			c.Assume(FromRequest(r, a), IsNil)
+			c.Expect(a.Id.Native, Equals, datatype.Id(7))
+			c.Expect(a.Count, Equals, 3)
+
+			c.Specify("with the properties of the body taking precedence", func() {
+				c.Expect(a.Name, Equals, "a name")
+			})
+		})
+
+		c.Specify("from a multipart form with a file", func() {
+			body := &bytes.Buffer{}
+			w := multipart.NewWriter(body)
+			c.Assume(w.WriteField("id", "7"), IsNil)
+			c.Assume(w.WriteField("Address.Zip", "12345"), IsNil)
+			fw, err := w.CreateFormFile("image", "image.png")
+			c.Assume(err, IsNil)
+			_, err = fw.Write([]byte("image bytes"))
+			c.Assume(err, IsNil)
+			c.Assume(w.Close(), IsNil)
+
+			r := httptest.NewRequest("POST", "/", body)
+			r.Header.Set("Content-Type", w.FormDataContentType())
+
+			c.Assume(FromRequest(r, a), IsNil)
+			c.Expect(a.Id.Native, Equals, datatype.Id(7))
+			c.Assume(a.Image.File, Not(IsNil))
+			c.Expect(a.Image.Header.Filename, Equals, "image.png")
+			c.Expect(a.Image.Field, Equals, "image")
+
+			contents, err := ioutil.ReadAll(a.Image.File)
+			c.Assume(err, IsNil)
+			c.Expect(string(contents), Equals, "image bytes")
+		})
+	})
+
+	c.Specify("the files bound to an action are closed", func() {
+		file := &closeSpyFile{strings.NewReader("image bytes"), false}
+		a := &mockUploadAction{Image: datatype.FormFile{File: file}}
+
+		c.Expect(CloseFiles(a), IsNil)
+		c.Expect(file.closed, IsTrue)
+
+		c.Specify("unless they can't be closed", func() {
+			a.Image.File = strings.NewReader("image bytes")
+			c.Expect(CloseFiles(a), IsNil)
+		})
+	})
+
+	c.Specify("binding an action", func() {
+		a := &mockUploadAction{}
+
+		verr := func(err error) *ValidationError {
+			var verr *ValidationError
+			c.Assume(errors.As(err, &verr), IsTrue)
+			return verr
+		}
+
+		c.Specify("reports input that can't be converted", func() {
+			r := httptest.NewRequest("POST", "/?id=7&count=three&Address.Zip=12345", nil)
+
+			fields := verr(FromRequest(r, a)).Fields
+			c.Assume(len(fields), Equals, 1)
+			c.Expect(fields[0].Field, Equals, "count")
+			c.Expect(fields[0].Str, Equals, "three")
+			c.Expect(fields[0].Rule, Equals, "type")
+		})
+
+		c.Specify("reports a malformed JSON body", func() {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(`{"id":`))
+			r.Header.Set("Content-Type", "application/json")
+
+			fields := verr(FromRequest(r, a)).Fields
+			c.Assume(len(fields), Equals, 1)
+			c.Expect(fields[0].Rule, Equals, "json")
+		})
+
+		c.Specify("validates the action", func() {
+			r := httptest.NewRequest("POST", "/?price=-1&Address.Zip=12345", nil)
+
+			fields := verr(FromRequest(r, a)).Fields
+			c.Assume(len(fields), Equals, 2)
+			c.Expect(fields[0].Field, Equals, "Id")
+			c.Expect(fields[0].Rule, Equals, "required")
+			c.Expect(fields[1].Field, Equals, "Price")
+			c.Expect(fields[1].Rule, Equals, "min")
+		})
+	})
+}
+
+type closeSpyFile struct {
+	*strings.Reader
+	closed bool
+}
+
+func (f *closeSpyFile) Close() error {
+	f.closed = true
+	return nil
+}
@@ -9,6 +9,9 @@ func TestUnitSpecs(t *testing.T) {
 	r := gospec.NewRunner()
 
 	r.AddSpec(DescribeDatatypeConversions)
+	r.AddSpec(DescribeValidationError)
+	r.AddSpec(DescribeValidate)
+	r.AddSpec(DescribeFromRequest)
 	r.AddSpec(DescribeActions)
 
 	gospec.MainGoTest(r, t)
@@ -0,0 +1,204 @@
+package action
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Validate walks the fields of the action, which must be a struct or
+// a pointer to one, parsing every helper type and checking the rules
+// declared in each field's `action` tag. The rules are
+//
+//	required      the field's input must not be empty
+//	min=N, max=N  bounds the value of a number, Id or Float, the total
+//	              minutes of a TimeSpan, or the length of a string
+//	regexp=RE     the field's input must match RE, this must be the last rule
+//
+// A tag of "-" skips the field. Nested structs are validated with their
+// field names joined by '.'. Pass a pointer if the parsed Native values
+// are to be kept, a struct passed by value is validated as a copy.
+//
+// A malformed tag will panic. The failures are returned as a *ValidationError.
+func Validate(a interface{}) error {
+	v := reflect.ValueOf(a)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	} else {
+		copy := reflect.New(v.Type()).Elem()
+		copy.Set(v)
+		v = copy
+	}
+
+	if v.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("action: Validate requires a struct, got %s", v.Type()))
+	}
+
+	verr := &ValidationError{}
+	validateStruct(verr, "", v)
+	return verr.Err()
+}
+
+type fieldRules struct {
+	required bool
+	min, max *float64
+	regexp   *regexp.Regexp
+}
+
+var regexpCache = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+func compileRegexp(expr string) *regexp.Regexp {
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+
+	re, exists := regexpCache.m[expr]
+	if !exists {
+		re = regexp.MustCompile(expr)
+		regexpCache.m[expr] = re
+	}
+	return re
+}
+
+func parseRules(tag string) (r fieldRules) {
+	for tag != "" {
+		var rule string
+		if strings.HasPrefix(tag, "regexp=") {
+			rule, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			rule, tag = tag[:i], tag[i+1:]
+		} else {
+			rule, tag = tag, ""
+		}
+
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+
+		switch name {
+		case "required":
+			r.required = true
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("action: invalid %s rule %q", name, arg))
+			}
+			if name == "min" {
+				r.min = &n
+			} else {
+				r.max = &n
+			}
+		case "regexp":
+			r.regexp = compileRegexp(arg)
+		default:
+			panic(fmt.Sprintf("action: unknown rule %q", rule))
+		}
+	}
+	return
+}
+
+// The value that min and max are compared against
+func (f Float) number() float64    { return f.Native }
+func (t TimeSpan) number() float64 { return float64(t.Hours*60 + t.Mins) }
+func (p Id) number() float64       { return float64(p.Native) }
+
+type numeric interface {
+	number() float64
+}
+
+var fieldParserType = reflect.TypeOf((*FieldParser)(nil)).Elem()
+
+func validateStruct(verr *ValidationError, prefix string, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		tag := sf.Tag.Get("action")
+		if tag == "-" {
+			continue
+		}
+
+		validateField(verr, prefix+sf.Name, v.Field(i), parseRules(tag))
+	}
+}
+
+func validateField(verr *ValidationError, field string, v reflect.Value, rules fieldRules) {
+	switch {
+	case v.Addr().Type().Implements(fieldParserType):
+		str := v.FieldByName("Str").String()
+		if str == "" {
+			if rules.required {
+				verr.Add(field, str, "required", "is required")
+			}
+			return
+		}
+
+		if !verr.Check(field, str, "parse", v.Addr().Interface().(FieldParser).ParseField(field)) {
+			return
+		}
+
+		checkBounds(verr, field, str, v.Interface().(numeric).number(), rules)
+		checkRegexp(verr, field, str, rules)
+
+	case v.Kind() == reflect.Struct:
+		validateStruct(verr, field+".", v)
+
+	case v.Kind() == reflect.String:
+		str := v.String()
+		if str == "" {
+			if rules.required {
+				verr.Add(field, str, "required", "is required")
+			}
+			return
+		}
+
+		checkBounds(verr, field, str, float64(utf8.RuneCountInString(str)), rules)
+		checkRegexp(verr, field, str, rules)
+
+	default:
+		var n float64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = v.Float()
+		default:
+			return
+		}
+
+		str := fmt.Sprint(v.Interface())
+		if rules.required && n == 0 {
+			verr.Add(field, str, "required", "is required")
+			return
+		}
+
+		checkBounds(verr, field, str, n, rules)
+	}
+}
+
+func checkBounds(verr *ValidationError, field, str string, n float64, rules fieldRules) {
+	if rules.min != nil && n < *rules.min {
+		verr.Add(field, str, "min", fmt.Sprintf("must be at least %v", *rules.min))
+	}
+	if rules.max != nil && n > *rules.max {
+		verr.Add(field, str, "max", fmt.Sprintf("must be at most %v", *rules.max))
+	}
+}
+
+func checkRegexp(verr *ValidationError, field, str string, rules fieldRules) {
+	if rules.regexp != nil && !rules.regexp.MatchString(str) {
+		verr.Add(field, str, "regexp", fmt.Sprintf("must match %s", rules.regexp))
+	}
+}
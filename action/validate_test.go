@@ -0,0 +1,119 @@
+package action
+
+import (
+	"errors"
+	"github.com/ghthor/database/datatype"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+)
+
+type mockAddress struct {
+	Zip string `action:"required,regexp=^[0-9]{5}$"`
+}
+
+type mockTaggedAction struct {
+	Id       Id       `action:"required"`
+	Price    Float    `action:"min=0,max=100"`
+	Duration TimeSpan `action:"max=120"`
+	Name     string   `action:"required,max=8"`
+	Code     string   `action:"regexp=^[a-z]{1,3}(,[a-z]{1,3})*$"`
+	Count    int      `action:"min=1"`
+	Address  mockAddress
+	Ignored  Id `action:"-"`
+}
+
+func (a *mockTaggedAction) IsValid() error { return Validate(a) }
+
+func DescribeValidate(c gospec.Context) {
+	c.Specify("validating an action by its tags", func() {
+		a := &mockTaggedAction{
+			Id:       Id{Str: "7"},
+			Price:    Float{Str: "9.99"},
+			Duration: TimeSpan{Str: "1:30"},
+			Name:     "name",
+			Code:     "ab,cd",
+			Count:    1,
+			Address:  mockAddress{"12345"},
+			Ignored:  Id{Str: "not an id"},
+		}
+
+		fields := func() []FieldError {
+			err := a.IsValid()
+			c.Assume(err, Not(IsNil))
+
+			var verr *ValidationError
+			c.Assume(errors.As(err, &verr), IsTrue)
+
+			fields := make([]FieldError, 0, len(verr.Fields))
+			for _, f := range verr.Fields {
+				fields = append(fields, *f)
+			}
+			return fields
+		}
+
+		c.Specify("parses every helper type", func() {
+			c.Assume(a.IsValid(), IsNil)
+			c.Expect(a.Id.Native, Equals, datatype.Id(7))
+			c.Expect(a.Price.Native, Equals, 9.99)
+			c.Expect(a.Duration.Mins, Equals, uint64(30))
+		})
+
+		c.Specify("doesn't parse an empty helper that isn't required", func() {
+			a.Price.Str = ""
+			c.Expect(a.IsValid(), IsNil)
+		})
+
+		c.Specify("reports a helper that fails to parse", func() {
+			a.Price.Str = "free"
+			c.Expect(fields(), ContainsExactly, []FieldError{{"Price", "free", "parse", "invalid float", ErrInvalidFloat}})
+		})
+
+		c.Specify("reports required fields that are empty", func() {
+			a.Id.Str = ""
+			a.Name = ""
+			a.Address.Zip = ""
+			c.Expect(fields(), ContainsExactly, []FieldError{
+				{"Id", "", "required", "is required", nil},
+				{"Name", "", "required", "is required", nil},
+				{"Address.Zip", "", "required", "is required", nil},
+			})
+		})
+
+		c.Specify("reports values out of bounds", func() {
+			a.Price.Str = "100.01"
+			a.Duration.Str = "2:01"
+			a.Name = "too long name"
+			a.Count = 0
+			c.Expect(fields(), ContainsExactly, []FieldError{
+				{"Price", "100.01", "max", "must be at most 100", nil},
+				{"Duration", "2:01", "max", "must be at most 120", nil},
+				{"Name", "too long name", "max", "must be at most 8", nil},
+				{"Count", "0", "min", "must be at least 1", nil},
+			})
+		})
+
+		c.Specify("reports values that don't match a regexp", func() {
+			a.Code = "abcd"
+			a.Address.Zip = "1234"
+			c.Expect(fields(), ContainsExactly, []FieldError{
+				{"Code", "abcd", "regexp", "must match ^[a-z]{1,3}(,[a-z]{1,3})*$", nil},
+				{"Address.Zip", "1234", "regexp", "must match ^[0-9]{5}$", nil},
+			})
+		})
+
+		c.Specify("validates a copy of an action passed by value", func() {
+			c.Expect(Validate(*a), IsNil)
+			c.Expect(a.Id.Native, Equals, datatype.Id(0))
+		})
+	})
+
+	c.Specify("validating an action with a malformed tag panics", func() {
+		defer func() {
+			c.Expect(recover(), Equals, `action: unknown rule "requird"`)
+		}()
+
+		Validate(struct {
+			Name string `action:"requird"`
+		}{})
+	})
+}
@@ -0,0 +1,84 @@
+package action
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A FieldError describes why a single field of an action is invalid
+type FieldError struct {
+	// The path to the field, nested fields are separated by '.'
+	Field string
+	// The input that was rejected
+	Str string
+	// The rule that was broken, such as "parse" or "required"
+	Rule    string
+	Message string
+
+	// The sentinel error, if any, that caused the failure such as ErrInvalidId
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// A ValidationError collects every field of an action that is invalid
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Error())
+	}
+	return fmt.Sprintf("invalid fields [%s]", strings.Join(msgs, ", "))
+}
+
+func (e *ValidationError) Add(field, str, rule, message string) {
+	e.Fields = append(e.Fields, &FieldError{field, str, rule, message, nil})
+}
+
+// Adds err if it isn't nil. A *FieldError is added as is, anything
+// else is added as a failure of the rule for the field.
+func (e *ValidationError) Check(field, str, rule string, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	if fe, ok := err.(*FieldError); ok {
+		e.Fields = append(e.Fields, fe)
+	} else {
+		e.Fields = append(e.Fields, &FieldError{field, str, rule, err.Error(), err})
+	}
+	return false
+}
+
+// Implemented by the helper types Id, Float and TimeSpan
+type FieldParser interface {
+	ParseField(field string) error
+}
+
+// Parses the helper, adding a FieldError for the field if it fails
+func (e *ValidationError) Parse(field string, p FieldParser) bool {
+	return e.Check(field, "", "parse", p.ParseField(field))
+}
+
+// Returns nil if no fields are invalid.
+// Use this as the return value of IsValid.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func parseFieldError(field, str string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &FieldError{field, str, "parse", err.Error(), err}
+}
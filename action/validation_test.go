@@ -0,0 +1,71 @@
+package action
+
+import (
+	"errors"
+	"github.com/ghthor/database/datatype"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+)
+
+type mockCreateAction struct {
+	Id       Id
+	Price    Float
+	Duration TimeSpan
+}
+
+func (a *mockCreateAction) IsValid() error {
+	verr := &ValidationError{}
+	verr.Parse("Id", &a.Id)
+	verr.Parse("Price", &a.Price)
+	verr.Parse("Duration", &a.Duration)
+	return verr.Err()
+}
+
+func DescribeValidationError(c gospec.Context) {
+	c.Specify("a validation error", func() {
+		a := &mockCreateAction{
+			Id:       Id{Str: "1"},
+			Price:    Float{Str: "2.5"},
+			Duration: TimeSpan{Str: "1:30"},
+		}
+
+		c.Specify("is nil when every field is valid", func() {
+			c.Expect(a.IsValid(), IsNil)
+			c.Expect(a.Id.Native, Equals, datatype.Id(1))
+		})
+
+		c.Specify("collects every field that failed to parse", func() {
+			a.Id.Str = "one"
+			a.Duration.Str = "90"
+
+			err := a.IsValid()
+			c.Assume(err, Not(IsNil))
+
+			var verr *ValidationError
+			c.Assume(errors.As(err, &verr), IsTrue)
+			c.Assume(len(verr.Fields), Equals, 2)
+
+			c.Expect(*verr.Fields[0], Equals, FieldError{"Id", "one", "parse", "invalid id", ErrInvalidId})
+			c.Expect(*verr.Fields[1], Equals, FieldError{"Duration", "90", "parse", "invalid timeSpan", ErrInvalidTimeSpan})
+
+			c.Specify("that can be matched against the helper's error", func() {
+				c.Expect(errors.Is(verr.Fields[0], ErrInvalidId), IsTrue)
+			})
+
+			c.Specify("and describes them", func() {
+				c.Expect(err.Error(), Equals, "invalid fields [Id: invalid id, Duration: invalid timeSpan]")
+			})
+		})
+
+		c.Specify("can have failures added by an action", func() {
+			verr := &ValidationError{}
+			verr.Add("Name", "", "required", "is required")
+			verr.Check("Price", "-1", "min", errors.New("must be positive"))
+			verr.Check("Other", "", "", nil)
+
+			c.Assume(len(verr.Fields), Equals, 2)
+			c.Expect(verr.Fields[0].Rule, Equals, "required")
+			c.Expect(verr.Fields[1].Message, Equals, "must be positive")
+		})
+	})
+}
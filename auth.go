@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"github.com/ghthor/database/action"
+)
+
+// The caller an action is being executed on behalf of
+type Principal interface {
+	Roles() []string
+}
+
+// An action that carries the principal it's being executed on behalf of
+type PrincipalAction interface {
+	action.A
+	Principal() Principal
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// The principal on the context takes precedence over one carried by the action
+func principalFor(ctx context.Context, a action.A) Principal {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return p
+	}
+	if a, ok := a.(PrincipalAction); ok {
+		return a.Principal()
+	}
+	return nil
+}
+
+// An Authorizer decides if a principal may execute an action.
+// The principal is nil if the caller didn't provide one and
+// roles are those required by the action's binding.
+type Authorizer interface {
+	Authorize(p Principal, a action.A, roles []string) bool
+}
+
+// Authorizes a principal that has at least one of the roles required.
+// An action that doesn't require any roles is authorized for everyone.
+type RoleAuthorizer struct{}
+
+func (RoleAuthorizer) Authorize(p Principal, a action.A, roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+
+	if p == nil {
+		return false
+	}
+
+	for _, has := range p.Roles() {
+		for _, required := range roles {
+			if has == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Consult the Authorizer before every action is executed
+func WithAuthorizer(a Authorizer) Option {
+	return func(db *Database) error {
+		db.authorizer = a
+		return nil
+	}
+}
+
+// Declares the roles an Authorizer will require to execute the action type
+func RequireRoles(roles ...string) BindingOption {
+	return func(b *ActionBinding) {
+		b.Roles = append(b.Roles, roles...)
+	}
+}
+
+func authorizing(e Executor, authorizer Authorizer, roles []string) Executor {
+	return ExecutorFunc(func(ctx context.Context, a action.A) (interface{}, error) {
+		if !authorizer.Authorize(principalFor(ctx, a), a, roles) {
+			return nil, Err{ErrResourceForbidden}
+		}
+		return ExecuteWithContext(ctx, e, a)
+	})
+}
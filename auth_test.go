@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+)
+
+type MockPrincipal []string
+
+func (p MockPrincipal) Roles() []string { return p }
+
+type MockPrincipalAction struct {
+	MockValidAction
+	principal Principal
+}
+
+func (a MockPrincipalAction) Principal() Principal { return a.principal }
+
+func DescribeAuthorization(c gospec.Context) {
+	ex := &MockExecutor{}
+	newEx := func(DatabaseConn) (Executor, error) { return ex, nil }
+
+	r := NewExecutorRegistry()
+	c.Assume(r.Register(MockValidAction(""), newEx, RequireRoles("admin", "editor")), IsNil)
+	c.Assume(r.Register(MockPrincipalAction{}, newEx, RequireRoles("admin")), IsNil)
+	c.Assume(r.Register(MockInvalidAction(""), newEx), IsNil)
+
+	db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(r), WithAuthorizer(RoleAuthorizer{}))
+	c.Assume(err, IsNil)
+
+	c.Specify("an authorized database", func() {
+		c.Specify("executes an action for a principal with a required role", func() {
+			ctx := WithPrincipal(context.Background(), MockPrincipal{"editor"})
+
+			_, err := db.ExecuteContext(ctx, MockValidAction(""))
+			c.Expect(err, IsNil)
+			c.Expect(ex.ExecuteWasCalled, IsTrue)
+		})
+
+		c.Specify("forbids a principal without a required role", func() {
+			ctx := WithPrincipal(context.Background(), MockPrincipal{"viewer"})
+
+			_, err := db.ExecuteContext(ctx, MockValidAction(""))
+			c.Expect(err, Equals, Err{ErrResourceForbidden})
+			c.Expect(errors.Is(err, ErrResourceForbidden), IsTrue)
+			c.Expect(ex.ExecuteWasCalled, IsFalse)
+		})
+
+		c.Specify("forbids a caller without a principal", func() {
+			_, err := db.Execute(MockValidAction(""))
+			c.Expect(err, Equals, Err{ErrResourceForbidden})
+		})
+
+		c.Specify("checks authorization before validity", func() {
+			child := r.NewChild()
+			c.Assume(child.Register(MockInvalidAction(""), newEx, RequireRoles("admin")), IsNil)
+			db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(child), WithAuthorizer(RoleAuthorizer{}))
+			c.Assume(err, IsNil)
+
+			_, err = db.Execute(MockInvalidAction(""))
+			c.Expect(err, Equals, Err{ErrResourceForbidden})
+		})
+
+		c.Specify("executes an action that doesn't require a role", func() {
+			_, err := db.Execute(MockInvalidAction(""))
+			c.Expect(errors.Is(err, ErrInvalidAction), IsTrue)
+		})
+
+		c.Specify("uses the principal carried by the action", func() {
+			_, err := db.Execute(MockPrincipalAction{principal: MockPrincipal{"admin"}})
+			c.Expect(err, IsNil)
+
+			_, err = db.Execute(MockPrincipalAction{principal: MockPrincipal{"editor"}})
+			c.Expect(err, Equals, Err{ErrResourceForbidden})
+
+			c.Specify("unless there is a principal on the context", func() {
+				ctx := WithPrincipal(context.Background(), MockPrincipal{"editor"})
+
+				_, err := db.ExecuteContext(ctx, MockPrincipalAction{principal: MockPrincipal{"admin"}})
+				c.Expect(err, Equals, Err{ErrResourceForbidden})
+			})
+		})
+	})
+
+	c.Specify("a database without an authorizer doesn't check roles", func() {
+		db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(r))
+		c.Assume(err, IsNil)
+
+		_, err = db.Execute(MockValidAction(""))
+		c.Expect(err, IsNil)
+	})
+}
@@ -0,0 +1,385 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ghthor/database/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The contents of a blob opened with BlobStore.Get
+type Blob interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+type BlobInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// A BlobStore is where the files saved by a Transaction are kept.
+// Names are '/' separated paths relative to the root of the store.
+// A missing blob is reported with an error that satisfies os.IsNotExist.
+type BlobStore interface {
+	// Stores the contents of r, replacing any blob with the same name
+	Put(name string, r io.Reader) error
+	Get(name string) (Blob, error)
+	Delete(name string) error
+	// Moves a blob to a new name, replacing any blob already using it
+	Rename(from, to string) error
+	Stat(name string) (BlobInfo, error)
+	// The names of every blob in the store sorted lexically
+	List() ([]string, error)
+}
+
+// Opens the store selected by the config's fileStore. "dir", the
+// default, stores blobs in the fileSystemDB directory and "memory"
+// stores blobs in memory. A fileLayout of "sharded" wraps the store
+// in a ShardedBlobStore.
+func OpenBlobStore(cfg config.Config) (BlobStore, error) {
+	var store BlobStore
+
+	switch cfg.FileStore {
+	case "", "dir":
+		store = NewDirBlobStore(cfg.FileSystemDB)
+	case "memory":
+		store = NewMemBlobStore()
+	default:
+		return nil, fmt.Errorf("unknown file store %q", cfg.FileStore)
+	}
+
+	switch cfg.FileLayout {
+	case "", "flat":
+		return store, nil
+	case "sharded":
+		return NewShardedBlobStore(store), nil
+	}
+	return nil, fmt.Errorf("unknown file layout %q", cfg.FileLayout)
+}
+
+// Blobs are written to a temporary file with this prefix
+// and renamed into place once they're complete
+const tempBlobPrefix = ".tmp-"
+
+func validBlobName(name string) bool {
+	return name != "" && path.Clean(name) == name && !path.IsAbs(name) &&
+		name != ".." && !strings.HasPrefix(name, "../") &&
+		!strings.HasPrefix(path.Base(name), tempBlobPrefix)
+}
+
+func errBlobNotExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// Stores blobs as files in a local directory.
+// A blob is written to a temporary file in the same directory, synced to disk,
+// then renamed into place so a crash never leaves a partial blob under its name.
+type DirBlobStore struct {
+	dir string
+}
+
+func NewDirBlobStore(dir string) *DirBlobStore {
+	return &DirBlobStore{dir}
+}
+
+func (s *DirBlobStore) Dir() string { return s.dir }
+
+func (s *DirBlobStore) path(op, name string) (string, error) {
+	if !validBlobName(name) {
+		return "", &os.PathError{Op: op, Path: name, Err: os.ErrInvalid}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(name)), nil
+}
+
+func (s *DirBlobStore) Put(name string, r io.Reader) error {
+	filename, err := s.path("put", name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(filename)
+
+	file, err := ioutil.TempFile(dir, tempBlobPrefix)
+	if err != nil {
+		return err
+	}
+
+	err = writeSynced(file, r)
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// Copies r into the file and flushes it to disk before closing it
+func writeSynced(file *os.File, r io.Reader) error {
+	_, err := io.Copy(file, r)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Persist a rename within the directory, not every platform supports this
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// Walks the regular files in the store, a store that hasn't been created yet is empty
+func (s *DirBlobStore) walk(fn func(filename string, info os.FileInfo) error) error {
+	return filepath.Walk(s.dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			if filename == s.dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return fn(filename, info)
+	})
+}
+
+// Removes the temporary files left behind by writes that were interrupted by a crash.
+// This is run by RecoverFiles and must not run while another process is writing to the directory.
+func (s *DirBlobStore) Sweep() error {
+	return s.walk(func(filename string, info os.FileInfo) error {
+		if strings.HasPrefix(info.Name(), tempBlobPrefix) {
+			return os.Remove(filename)
+		}
+		return nil
+	})
+}
+
+func (s *DirBlobStore) Get(name string) (Blob, error) {
+	filename, err := s.path("get", name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, errBlobNotExist("get", name)
+	}
+
+	return file, nil
+}
+
+func (s *DirBlobStore) Delete(name string) error {
+	filename, err := s.path("delete", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+func (s *DirBlobStore) Rename(from, to string) error {
+	fromFilename, err := s.path("rename", from)
+	if err != nil {
+		return err
+	}
+
+	toFilename, err := s.path("rename", to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.Stat(from); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(toFilename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(fromFilename, toFilename); err != nil {
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+func (s *DirBlobStore) Stat(name string) (BlobInfo, error) {
+	filename, err := s.path("stat", name)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	if !info.Mode().IsRegular() {
+		return BlobInfo{}, errBlobNotExist("stat", name)
+	}
+
+	return BlobInfo{name, info.Size(), info.ModTime()}, nil
+}
+
+func (s *DirBlobStore) List() ([]string, error) {
+	var names []string
+
+	err := s.walk(func(filename string, info os.FileInfo) error {
+		if strings.HasPrefix(info.Name(), tempBlobPrefix) {
+			return nil
+		}
+
+		name, err := filepath.Rel(s.dir, filename)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+
+	sort.Strings(names)
+	return names, err
+}
+
+type memBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+// Stores blobs in memory, useful for testing
+type MemBlobStore struct {
+	mu    sync.RWMutex
+	blobs map[string]memBlob
+}
+
+func NewMemBlobStore() *MemBlobStore {
+	return &MemBlobStore{blobs: make(map[string]memBlob)}
+}
+
+func (s *MemBlobStore) Put(name string, r io.Reader) error {
+	if !validBlobName(name) {
+		return &os.PathError{Op: "put", Path: name, Err: os.ErrInvalid}
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blobs[name] = memBlob{data, time.Now()}
+	return nil
+}
+
+type memBlobReader struct {
+	*bytes.Reader
+}
+
+func (memBlobReader) Close() error { return nil }
+
+func (s *MemBlobStore) Get(name string) (Blob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blob, exists := s.blobs[name]
+	if !exists {
+		return nil, errBlobNotExist("get", name)
+	}
+	return memBlobReader{bytes.NewReader(blob.data)}, nil
+}
+
+func (s *MemBlobStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.blobs[name]; !exists {
+		return errBlobNotExist("delete", name)
+	}
+	delete(s.blobs, name)
+	return nil
+}
+
+func (s *MemBlobStore) Rename(from, to string) error {
+	if !validBlobName(to) {
+		return &os.PathError{Op: "rename", Path: to, Err: os.ErrInvalid}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, exists := s.blobs[from]
+	if !exists {
+		return errBlobNotExist("rename", from)
+	}
+
+	delete(s.blobs, from)
+	s.blobs[to] = blob
+	return nil
+}
+
+func (s *MemBlobStore) Stat(name string) (BlobInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blob, exists := s.blobs[name]
+	if !exists {
+		return BlobInfo{}, errBlobNotExist("stat", name)
+	}
+	return BlobInfo{name, int64(len(blob.data)), blob.modTime}, nil
+}
+
+func (s *MemBlobStore) List() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.blobs))
+	for name := range s.blobs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
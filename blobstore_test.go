@@ -0,0 +1,262 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"github.com/ghthor/database/config"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("read failed") }
+
+func describeBlobStore(c gospec.Context, store BlobStore) {
+	c.Assume(store.Put("a/blob", strings.NewReader("contents")), IsNil)
+
+	c.Specify("can get a blob that was put", func() {
+		blob, err := store.Get("a/blob")
+		c.Assume(err, IsNil)
+		defer blob.Close()
+
+		contents, err := ioutil.ReadAll(blob)
+		c.Assume(err, IsNil)
+		c.Expect(string(contents), Equals, "contents")
+
+		c.Specify("and seek within it", func() {
+			_, err := blob.Seek(3, 0)
+			c.Assume(err, IsNil)
+
+			contents, err := ioutil.ReadAll(blob)
+			c.Assume(err, IsNil)
+			c.Expect(string(contents), Equals, "tents")
+		})
+	})
+
+	c.Specify("can replace a blob", func() {
+		c.Assume(store.Put("a/blob", strings.NewReader("replaced")), IsNil)
+
+		info, err := store.Stat("a/blob")
+		c.Assume(err, IsNil)
+		c.Expect(info.Size, Equals, int64(len("replaced")))
+	})
+
+	c.Specify("can stat a blob", func() {
+		info, err := store.Stat("a/blob")
+		c.Assume(err, IsNil)
+		c.Expect(info.Name, Equals, "a/blob")
+		c.Expect(info.Size, Equals, int64(len("contents")))
+	})
+
+	c.Specify("can list the blobs", func() {
+		c.Assume(store.Put("b", bytes.NewReader(nil)), IsNil)
+
+		names, err := store.List()
+		c.Assume(err, IsNil)
+		c.Expect(strings.Join(names, ","), Equals, "a/blob,b")
+	})
+
+	c.Specify("can delete a blob", func() {
+		c.Assume(store.Delete("a/blob"), IsNil)
+
+		_, err := store.Stat("a/blob")
+		c.Expect(os.IsNotExist(err), IsTrue)
+
+		_, err = store.Get("a/blob")
+		c.Expect(os.IsNotExist(err), IsTrue)
+
+		c.Expect(os.IsNotExist(store.Delete("a/blob")), IsTrue)
+	})
+
+	c.Specify("reports a directory as missing", func() {
+		_, err := store.Stat("a")
+		c.Expect(os.IsNotExist(err), IsTrue)
+
+		_, err = store.Get("a")
+		c.Expect(os.IsNotExist(err), IsTrue)
+	})
+
+	c.Specify("rejects names outside of the store", func() {
+		c.Expect(store.Put("../escaped", strings.NewReader("")), Not(IsNil))
+		c.Expect(store.Put("/abs", strings.NewReader("")), Not(IsNil))
+		c.Expect(store.Put("a/../b", strings.NewReader("")), Not(IsNil))
+	})
+
+	c.Specify("doesn't keep a blob that failed to be read", func() {
+		c.Expect(store.Put("failed", failingReader{}), Not(IsNil))
+
+		_, err := store.Stat("failed")
+		c.Expect(os.IsNotExist(err), IsTrue)
+	})
+}
+
+func DescribeBlobStores(c gospec.Context) {
+	c.Specify("a directory blob store", func() {
+		tmp, err := ioutil.TempDir("", "blobstore-spec")
+		c.Assume(err, IsNil)
+		defer func() { c.Assume(os.RemoveAll(tmp), IsNil) }()
+
+		store := NewDirBlobStore(tmp)
+		describeBlobStore(c, store)
+
+		c.Specify("writes blobs as files in the directory", func() {
+			contents, err := ioutil.ReadFile(filepath.Join(tmp, "a", "blob"))
+			c.Assume(err, IsNil)
+			c.Expect(string(contents), Equals, "contents")
+		})
+
+		c.Specify("with a temporary file left by a crash", func() {
+			orphan := filepath.Join(tmp, "a", tempBlobPrefix+"123")
+			c.Assume(ioutil.WriteFile(orphan, []byte("partial"), 0644), IsNil)
+
+			c.Specify("doesn't list it", func() {
+				names, err := store.List()
+				c.Assume(err, IsNil)
+				c.Expect(strings.Join(names, ","), Equals, "a/blob")
+			})
+
+			c.Specify("removes it when swept", func() {
+				c.Assume(store.Sweep(), IsNil)
+
+				_, err := os.Stat(orphan)
+				c.Expect(os.IsNotExist(err), IsTrue)
+
+				_, err = store.Stat("a/blob")
+				c.Expect(err, IsNil)
+			})
+
+			c.Specify("removes it when a database is created with file recovery", func() {
+				_, err := NewDatabase(&MysqlDatabase{}, store, WithExecutorRegistry(NewExecutorRegistry()), WithFileRecovery())
+				c.Assume(err, IsNil)
+
+				_, err = os.Stat(orphan)
+				c.Expect(os.IsNotExist(err), IsTrue)
+			})
+
+			c.Specify("keeps it when a database is created without file recovery", func() {
+				_, err := NewDatabase(&MysqlDatabase{}, store, WithExecutorRegistry(NewExecutorRegistry()))
+				c.Assume(err, IsNil)
+
+				_, err = os.Stat(orphan)
+				c.Expect(err, IsNil)
+			})
+
+			c.Specify("won't put a blob with a temporary name", func() {
+				c.Expect(store.Put("a/"+tempBlobPrefix+"123", strings.NewReader("")), Not(IsNil))
+			})
+		})
+
+		c.Specify("doesn't leave a temporary file behind after a failed write", func() {
+			c.Assume(store.Put("a/failed", failingReader{}), Not(IsNil))
+
+			files, err := ioutil.ReadDir(filepath.Join(tmp, "a"))
+			c.Assume(err, IsNil)
+			c.Expect(len(files), Equals, 1)
+		})
+	})
+
+	c.Specify("a directory blob store that hasn't been created", func() {
+		store := NewDirBlobStore(filepath.Join(os.TempDir(), "blobstore-spec-missing"))
+
+		names, err := store.List()
+		c.Expect(err, IsNil)
+		c.Expect(len(names), Equals, 0)
+		c.Expect(store.Sweep(), IsNil)
+	})
+
+	c.Specify("a memory blob store", func() {
+		describeBlobStore(c, NewMemBlobStore())
+	})
+
+	c.Specify("a sharded blob store", func() {
+		flat := NewMemBlobStore()
+		store := NewShardedBlobStore(flat)
+		describeBlobStore(c, store)
+
+		sha1Name := "1833207066f2835d021b9dc165b0485a06dcd6ce.txt"
+		sha256Name := "sha256-ee268254fcb0d0cbc2e86a9bce1313e7622eb7397937e34f9432a907c707ad37.txt"
+
+		c.Specify("puts a saved file in the directories of its digest", func() {
+			c.Assume(store.Put(sha256Name, strings.NewReader("sha256")), IsNil)
+			c.Assume(store.Put(sha1Name, strings.NewReader("sha1")), IsNil)
+
+			names, err := flat.List()
+			c.Assume(err, IsNil)
+			c.Expect(strings.Join(names, ","), Equals, "18/33/"+sha1Name+",a/blob,ee/26/"+sha256Name)
+
+			c.Specify("and lists it by its name", func() {
+				names, err := store.List()
+				c.Assume(err, IsNil)
+				c.Expect(strings.Join(names, ","), Equals, sha1Name+",a/blob,"+sha256Name)
+
+				info, err := store.Stat(sha256Name)
+				c.Assume(err, IsNil)
+				c.Expect(info.Name, Equals, sha256Name)
+			})
+		})
+
+		c.Specify("with a file in the flat layout", func() {
+			c.Assume(flat.Put(sha1Name, strings.NewReader("flat")), IsNil)
+
+			c.Specify("can get it by its name", func() {
+				blob, err := store.Get(sha1Name)
+				c.Assume(err, IsNil)
+				defer blob.Close()
+
+				contents, err := ioutil.ReadAll(blob)
+				c.Assume(err, IsNil)
+				c.Expect(string(contents), Equals, "flat")
+			})
+
+			c.Specify("can delete it", func() {
+				c.Expect(store.Delete(sha1Name), IsNil)
+
+				_, err := flat.Stat(sha1Name)
+				c.Expect(os.IsNotExist(err), IsTrue)
+			})
+
+			c.Specify("moves it into its directories when migrated", func() {
+				moved, err := store.Migrate()
+				c.Assume(err, IsNil)
+				c.Expect(strings.Join(moved, ","), Equals, sha1Name)
+
+				_, err = flat.Stat("18/33/" + sha1Name)
+				c.Expect(err, IsNil)
+
+				_, err = store.Stat(sha1Name)
+				c.Expect(err, IsNil)
+
+				moved, err = store.Migrate()
+				c.Assume(err, IsNil)
+				c.Expect(len(moved), Equals, 0)
+			})
+		})
+	})
+
+	c.Specify("a blob store is selected by the config", func() {
+		store, err := OpenBlobStore(config.Config{FileSystemDB: "dir"})
+		c.Assume(err, IsNil)
+		c.Expect(store.(*DirBlobStore).Dir(), Equals, "dir")
+
+		store, err = OpenBlobStore(config.Config{FileStore: "memory"})
+		c.Assume(err, IsNil)
+		_, isMem := store.(*MemBlobStore)
+		c.Expect(isMem, IsTrue)
+
+		store, err = OpenBlobStore(config.Config{FileStore: "memory", FileLayout: "sharded"})
+		c.Assume(err, IsNil)
+		_, isSharded := store.(*ShardedBlobStore)
+		c.Expect(isSharded, IsTrue)
+
+		_, err = OpenBlobStore(config.Config{FileStore: "s3"})
+		c.Expect(err, Not(IsNil))
+
+		_, err = OpenBlobStore(config.Config{FileLayout: "nested"})
+		c.Expect(err, Not(IsNil))
+	})
+}
@@ -10,6 +10,12 @@ type Config struct {
 	Password     string `json:"password"`
 	DefaultDB    string `json:"defaultDB"`
 	FileSystemDB string `json:"fileSystemDB"`
+	// "dir", the default, or "memory"
+	FileStore string `json:"fileStore"`
+	// "sha1", the default, "sha256" or "blake2b"
+	FileHash string `json:"fileHash"`
+	// "flat", the default, or "sharded"
+	FileLayout string `json:"fileLayout"`
 }
 
 func ReadFromFile(file string) (c Config, err error) {
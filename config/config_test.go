@@ -21,6 +21,9 @@ func DescribeConfigLoading(c gospec.Context) {
 			"dbpassword",
 			"dbname",
 			"filepath/to/filedb",
+			"dir",
+			"sha256",
+			"sharded",
 		}
 
 		config, err := ReadFromFile("config.example.json")
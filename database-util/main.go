@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"github.com/ghthor/database"
 	"github.com/ghthor/database/config"
 	"log"
 	"os"
@@ -11,6 +13,8 @@ import (
 func main() {
 	configFilepath := flag.String("config", "config.json", "Path to a database configuration file")
 	requirePwd := flag.Bool("require-password", false, "require the password to be typed to stdin")
+	migrateFiles := flag.Bool("migrate-files", false, "move the files in the file store into the sharded layout and exit, the fileLayout must be sharded and every process using the file store must be stopped")
+	recoverFiles := flag.Bool("recover-files", false, "finish promoting the files of interrupted transactions and exit, every process using the fileSystemDB must be stopped")
 
 	flag.Parse()
 
@@ -19,6 +23,34 @@ func main() {
 		log.Fatalf("error reading config: %s", err)
 	}
 
+	if *migrateFiles {
+		if cfg.FileLayout != "sharded" {
+			log.Fatalf("error migrating files: the fileLayout is %q, set it to \"sharded\" to migrate", cfg.FileLayout)
+		}
+
+		store, err := database.OpenBlobStore(cfg)
+		if err != nil {
+			log.Fatalf("error opening file store: %s", err)
+		}
+		moved, err := store.(*database.ShardedBlobStore).Migrate()
+		if err != nil {
+			log.Fatalf("error migrating files: %s", err)
+		}
+		fmt.Printf("moved %d files\n", len(moved))
+		return
+	}
+
+	if *recoverFiles {
+		store, err := database.OpenBlobStore(cfg)
+		if err != nil {
+			log.Fatalf("error opening file store: %s", err)
+		}
+		if err := database.RecoverFiles(store); err != nil {
+			log.Fatalf("error recovering files: %s", err)
+		}
+		return
+	}
+
 	var cmd *exec.Cmd
 	if *requirePwd {
 		cmd = exec.Command("mysqldump", "-d", "-u", cfg.Username, "-p", cfg.DefaultDB)
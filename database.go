@@ -1,22 +1,30 @@
 package database
 
 import (
+	"context"
 	"github.com/ghthor/database/action"
 	"github.com/ghthor/database/config"
 	"github.com/ziutek/mymysql/mysql"
 	_ "github.com/ziutek/mymysql/thrsafe"
 	"net/http"
-	"reflect"
 )
 
 type Db interface {
 	Execute(action.A) (interface{}, error)
+	ExecuteContext(context.Context, action.A) (interface{}, error)
 }
 
 type Executor interface {
 	ExecuteWith(action.A) (interface{}, error)
 }
 
+// An Executor that can be cancelled or given a deadline.
+// Database.ExecuteContext will prefer ExecuteWithContext when it's implemented.
+type ContextExecutor interface {
+	Executor
+	ExecuteWithContext(context.Context, action.A) (interface{}, error)
+}
+
 func New(cfg config.Config) (Db, error) {
 	conn := mysql.New("tcp", "", "127.0.0.1:3306", cfg.Username, cfg.Password, cfg.DefaultDB)
 	err := conn.Connect()
@@ -29,7 +37,12 @@ func New(cfg config.Config) (Db, error) {
 		return nil, err
 	}
 
-	db, err := NewDatabase(mysqlDb, cfg.FileSystemDB)
+	store, err := OpenBlobStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := NewDatabase(mysqlDb, store, WithFileHash(FileHash(cfg.FileHash)))
 	if err != nil {
 		return nil, err
 	}
@@ -47,50 +60,211 @@ type MymysqlConn interface {
 
 type DatabaseConn interface {
 	MysqlConn() MymysqlConn
-	Filepath() string
+	BlobStore() BlobStore
 	Begin() (Transaction, error)
+	InTx(func(Transaction) (interface{}, error)) (interface{}, error)
+	InTxRetry(context.Context, func(Transaction) (interface{}, error)) (interface{}, int, error)
+	// Prepares a statement once per SQL string, see StmtCache
+	Prepare(string) (mysql.Stmt, error)
 }
 
 type Database struct {
 	mysqlDb *MysqlDatabase
+	conn    MymysqlConn
 
-	filepath   string
+	blobStore  BlobStore
 	fileServer http.Handler
+	fileHash   FileHash
+	filePolicy FilePolicy
+
+	registry   *ExecutorRegistry
+	middleware []Middleware
+	authorizer Authorizer
+	executors  map[string]Executor
+
+	fileRefs     *fileRefs
+	fileRecords  *fileRecords
+	recoverFiles bool
+
+	retryPolicy RetryPolicy
+
+	stmts         *StmtCache
+	stmtCacheSize int
+}
+
+// An Option configures a Database during NewDatabase
+type Option func(*Database) error
+
+// Use the registry's bindings instead of those in the DefaultExecutorRegistry
+func WithExecutorRegistry(r *ExecutorRegistry) Option {
+	return func(db *Database) error {
+		db.registry = r
+		return nil
+	}
 }
 
-func NewDatabase(mysqlDb *MysqlDatabase, filepath string) (*Database, error) {
+func NewDatabase(mysqlDb *MysqlDatabase, blobStore BlobStore, opts ...Option) (*Database, error) {
 	db := &Database{
 		mysqlDb: mysqlDb,
+		conn:    mysqlDb,
 
-		filepath:   filepath,
-		fileServer: http.FileServer(http.Dir(filepath)),
+		blobStore:  blobStore,
+		fileServer: fileHandler{blobStore},
+		fileHash:   SHA1,
+
+		registry: DefaultExecutorRegistry(),
+
+		retryPolicy: DefaultRetryPolicy,
+
+		stmtCacheSize: DefaultStmtCacheSize,
+	}
+
+	for _, opt := range opts {
+		if err := opt(db); err != nil {
+			return nil, err
+		}
+	}
+
+	db.stmts = NewStmtCache(db.conn, db.stmtCacheSize)
+
+	if db.recoverFiles {
+		if err := RecoverFiles(blobStore); err != nil {
+			return nil, err
+		}
 	}
 
 	return db, db.PrepareActions()
 }
 
-func (c *Database) MysqlConn() MymysqlConn { return c.mysqlDb }
-func (c *Database) Filepath() string       { return c.filepath }
+func (c *Database) MysqlConn() MymysqlConn { return c.conn }
+func (c *Database) BlobStore() BlobStore   { return c.blobStore }
+func (c *Database) StmtCache() *StmtCache  { return c.stmts }
+
+// Statements that are kept, like an Executor's, should be prepared with
+// this instead of the MysqlConn so they're shared, see StmtCache.Prepare.
+// This mustn't be called while the goroutine has a transaction open.
+func (c *Database) Prepare(sql string) (mysql.Stmt, error) {
+	return c.stmts.Prepare(sql)
+}
+
 func (c *Database) Begin() (Transaction, error) {
+	if c.fileRefs != nil {
+		// Held until the transaction ends, see GarbageCollectFiles
+		c.fileRefs.mu.RLock()
+	}
+
 	tx, err := c.MysqlConn().Begin()
+	if err != nil {
+		if c.fileRefs != nil {
+			c.fileRefs.mu.RUnlock()
+		}
+		return nil, err
+	}
+	t := newTransaction(tx, c.blobStore)
+	if c.fileRefs != nil {
+		t.unlockRefs = c.fileRefs.mu.RUnlock
+	}
+	t.fileHash = c.fileHash
+	t.filePolicy = c.filePolicy
+	t.refs = c.fileRefs
+	t.records = c.fileRecords
+	t.stmts = c.stmts
+	return t, nil
+}
+
+// Runs fn within a transaction that is committed if fn succeeds. The transaction
+// is rolled back if fn returns an error or panics, the panic is resumed once it
+// has been rolled back. fn may commit the transaction itself. If fn succeeds but
+// the transaction was rolled back, such as by a statement that failed, the error
+// that rolled it back is returned, or ErrTxRolledBack if fn rolled it back.
+func (c *Database) InTx(fn func(Transaction) (interface{}, error)) (result interface{}, err error) {
+	tx, err := c.Begin()
 	if err != nil {
 		return nil, err
 	}
-	return newTransaction(tx, c.filepath), nil
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	result, err = fn(tx)
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil && rollbackErr != ErrTxDone {
+			return nil, RollbackError{rollbackErr, err}
+		}
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err == ErrTxDone {
+		err = rolledBackErr(tx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// The error for a transaction that had already ended, nil if it was committed
+func rolledBackErr(tx Transaction) error {
+	t, ok := tx.(*transaction)
+	switch {
+	case !ok || t.committed:
+		return nil
+	case t.abortErr != nil:
+		return t.abortErr
+	}
+	return ErrTxRolledBack
 }
 
-func (c *Database) MysqlDatabase() *MysqlDatabase { return c.mysqlDb }
+func (c *Database) MysqlDatabase() *MysqlDatabase       { return c.mysqlDb }
+func (c *Database) ExecutorRegistry() *ExecutorRegistry { return c.registry }
+
+// Instantiates an Executor for every action bound in the Database's registry
+// and wraps it with the Database's and the action's middleware.
+// The Authorizer is consulted inside of the middleware before the action's validity is checked.
+func (c *Database) PrepareActions() error {
+	executors, err := c.registry.newExecutors(c)
+	if err != nil {
+		return err
+	}
+
+	c.executors = make(map[string]Executor, len(executors))
+	for typename, executor := range executors {
+		binding, _ := c.registry.lookup(typename)
+
+		middleware := make([]Middleware, 0, len(c.middleware)+len(binding.Middleware))
+		middleware = append(middleware, c.middleware...)
+		middleware = append(middleware, binding.Middleware...)
+
+		executor = validating(enforcingFilePolicy(executor))
+		if c.authorizer != nil {
+			executor = authorizing(executor, c.authorizer, binding.Roles)
+		}
 
-func (c *Database) PrepareActions() (err error) {
-	return
+		c.executors[typename] = chainMiddleware(executor, middleware)
+	}
+
+	return nil
 }
 
 func (c *Database) Execute(A action.A) (interface{}, error) {
-	err := A.IsValid()
-	if err != nil {
+	return c.ExecuteContext(context.Background(), A)
+}
+
+func (c *Database) ExecuteContext(ctx context.Context, A action.A) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	fv := reflect.ValueOf(c).Elem().FieldByName(reflect.ValueOf(A).Type().Name())
-	return fv.Interface().(Executor).ExecuteWith(A)
+	executor, exists := c.executors[actionTypename(A)]
+	if !exists {
+		return nil, NoExecutorError{actionTypename(A)}
+	}
+
+	return ExecuteWithContext(ctx, executor, A)
 }
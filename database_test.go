@@ -1,19 +1,26 @@
 package database
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/ghthor/database/action"
+	"github.com/ghthor/database/datatype"
 	"github.com/ghthor/gospec"
 	. "github.com/ghthor/gospec"
+	"io/ioutil"
+	"strings"
 )
 
 func DescribeUpdateStmtResult(c gospec.Context) {
 	c.Specify("an update statement's result", func() {
-		updateResult := &UpdateResult{&MockResult{"(Rows matched: 1  Changed: 0  Warnings: 0"}}
+		updateResult := &UpdateResult{&MockResult{MessageStr: "(Rows matched: 1  Changed: 0  Warnings: 0"}}
 
 		c.Specify("can identify the number of rows that were matched", func() {
 			c.Expect(updateResult.MatchedRows(), Equals, uint64(1))
 
 			c.Specify("and panics if the message is in an unexpected format", func() {
-				updateResult.Result = &MockResult{"unexpected format: 0 panic: 0 mode: 0"}
+				updateResult.Result = &MockResult{MessageStr: "unexpected format: 0 panic: 0 mode: 0"}
 
 				defer func() {
 					e := recover()
@@ -25,3 +32,257 @@ func DescribeUpdateStmtResult(c gospec.Context) {
 		})
 	})
 }
+
+type MockExecutor struct {
+	ExecuteWasCalled bool
+	ExecuteFunc      func(action.A) (interface{}, error)
+}
+
+func (e *MockExecutor) ExecuteWith(a action.A) (interface{}, error) {
+	e.ExecuteWasCalled = true
+	if e.ExecuteFunc != nil {
+		return e.ExecuteFunc(a)
+	}
+	return nil, nil
+}
+
+type MockContextExecutor struct {
+	MockExecutor
+	Ctx context.Context
+}
+
+func (e *MockContextExecutor) ExecuteWithContext(ctx context.Context, a action.A) (interface{}, error) {
+	e.Ctx = ctx
+	return e.ExecuteWith(a)
+}
+
+type (
+	MockValidAction   string
+	MockInvalidAction string
+	MockUnboundAction string
+)
+
+func (MockValidAction) IsValid() error   { return nil }
+func (MockInvalidAction) IsValid() error { return errors.New("invalid") }
+func (MockUnboundAction) IsValid() error { return nil }
+
+type MockUploadAction string
+
+func (MockUploadAction) IsValid() error { return nil }
+func (MockUploadAction) FilePolicy() FilePolicy {
+	return FilePolicy{AllowedTypes: []string{"image/png"}}
+}
+
+type MockFileAction struct {
+	Image   datatype.FormFile `form:"image"`
+	MaxSize int64             `form:"-"`
+}
+
+func (MockFileAction) IsValid() error { return nil }
+func (a MockFileAction) FilePolicy() FilePolicy {
+	return FilePolicy{AllowedTypes: []string{"image/png"}, MaxSize: a.MaxSize}
+}
+
+type MockFieldsAction struct {
+	Id action.Id
+}
+
+func (a MockFieldsAction) IsValid() error {
+	verr := &action.ValidationError{}
+	verr.Parse("Id", &a.Id)
+	return verr.Err()
+}
+
+func DescribeDatabaseExecute(c gospec.Context) {
+	validEx := &MockExecutor{
+		ExecuteFunc: func(a action.A) (interface{}, error) {
+			return string(a.(MockValidAction)) + " executed", nil
+		},
+	}
+	invalidEx := &MockExecutor{}
+
+	var conn DatabaseConn
+
+	r := NewExecutorRegistry()
+
+	c.Assume(r.Register(MockValidAction(""), func(c DatabaseConn) (Executor, error) {
+		conn = c
+		return validEx, nil
+	}), IsNil)
+	c.Assume(r.Register(MockInvalidAction(""), func(DatabaseConn) (Executor, error) {
+		return invalidEx, nil
+	}), IsNil)
+	c.Assume(r.Register(MockFieldsAction{}, func(DatabaseConn) (Executor, error) {
+		return invalidEx, nil
+	}), IsNil)
+
+	db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(r))
+	c.Assume(err, IsNil)
+
+	c.Specify("a database", func() {
+		c.Specify("instantiates every registered executor", func() {
+			c.Expect(len(db.executors), Equals, 3)
+			c.Expect(conn, Equals, DatabaseConn(db))
+		})
+
+		c.Specify("dispatches an action to its registered executor", func() {
+			res, err := db.Execute(MockValidAction("action"))
+			c.Assume(err, IsNil)
+			c.Expect(res, Equals, "action executed")
+			c.Expect(validEx.ExecuteWasCalled, IsTrue)
+		})
+
+		c.Specify("will not execute an invalid action", func() {
+			_, err := db.Execute(MockInvalidAction(""))
+			c.Expect(errors.Is(err, ErrInvalidAction), IsTrue)
+			c.Expect(invalidEx.ExecuteWasCalled, IsFalse)
+		})
+
+		c.Specify("returns the action's validation error wrapped as an invalid action", func() {
+			_, err := db.Execute(MockFieldsAction{})
+
+			var verr *action.ValidationError
+			c.Assume(errors.As(err, &verr), IsTrue)
+			c.Expect(verr.Fields[0].Field, Equals, "Id")
+			c.Expect(errors.Is(err, ErrInvalidAction), IsTrue)
+		})
+
+		c.Specify("will not execute an action with a cancelled context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := db.ExecuteContext(ctx, MockValidAction(""))
+			c.Expect(err, Equals, context.Canceled)
+			c.Expect(validEx.ExecuteWasCalled, IsFalse)
+		})
+
+		c.Specify("returns an error if no executor is registered", func() {
+			_, err := db.Execute(MockUnboundAction(""))
+			c.Expect(err, Equals, NoExecutorError{"database.MockUnboundAction"})
+		})
+	})
+
+	c.Specify("a database will fail to be created if an executor fails to be instantiated", func() {
+		c.Assume(r.Register(MockUnboundAction(""), func(DatabaseConn) (Executor, error) {
+			return nil, errors.New("failed to prepare")
+		}), IsNil)
+
+		_, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(r))
+		c.Expect(err, Not(IsNil))
+	})
+
+	c.Specify("a database uses the default registry unless given one", func() {
+		db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore())
+		c.Assume(err, IsNil)
+		c.Expect(db.ExecutorRegistry() == DefaultExecutorRegistry(), IsTrue)
+	})
+
+	c.Specify("two databases can use different bindings for the same action", func() {
+		otherEx := &MockExecutor{}
+		child := r.NewChild()
+		c.Assume(child.Register(MockValidAction(""), func(DatabaseConn) (Executor, error) {
+			return otherEx, nil
+		}), IsNil)
+
+		other, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(child))
+		c.Assume(err, IsNil)
+
+		_, err = other.Execute(MockValidAction(""))
+		c.Assume(err, IsNil)
+		c.Expect(otherEx.ExecuteWasCalled, IsTrue)
+		c.Expect(validEx.ExecuteWasCalled, IsFalse)
+
+		_, err = db.Execute(MockValidAction(""))
+		c.Assume(err, IsNil)
+		c.Expect(validEx.ExecuteWasCalled, IsTrue)
+	})
+
+	c.Specify("a database passes the context to a context executor", func() {
+		ctxEx := &MockContextExecutor{}
+		child := r.NewChild()
+		c.Assume(child.Register(MockValidAction(""), func(DatabaseConn) (Executor, error) {
+			return ctxEx, nil
+		}), IsNil)
+
+		db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(child))
+		c.Assume(err, IsNil)
+
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "value")
+
+		_, err = db.ExecuteContext(ctx, MockValidAction(""))
+		c.Assume(err, IsNil)
+		c.Expect(ctxEx.ExecuteWasCalled, IsTrue)
+		c.Expect(ctxEx.Ctx == ctx, IsTrue)
+	})
+
+	c.Specify("a database passes the file policy of an action on the context", func() {
+		ctxEx := &MockContextExecutor{}
+		child := r.NewChild()
+		c.Assume(child.Register(MockUploadAction(""), func(DatabaseConn) (Executor, error) {
+			return ctxEx, nil
+		}), IsNil)
+
+		db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(child))
+		c.Assume(err, IsNil)
+
+		_, err = db.ExecuteContext(context.Background(), MockUploadAction(""))
+		c.Assume(err, IsNil)
+
+		policy, ok := filePolicyFromContext(ctxEx.Ctx)
+		c.Assume(ok, IsTrue)
+		c.Expect(strings.Join(policy.AllowedTypes, ","), Equals, "image/png")
+	})
+
+	c.Specify("a database checks the files of an action against its file policy", func() {
+		// Reads the file like saving it without the context would
+		var contents []byte
+		ex := &MockExecutor{
+			ExecuteFunc: func(a action.A) (interface{}, error) {
+				var err error
+				contents, err = ioutil.ReadAll(a.(MockFileAction).Image.File)
+				return nil, err
+			},
+		}
+		child := r.NewChild()
+		c.Assume(child.Register(MockFileAction{}, func(DatabaseConn) (Executor, error) {
+			return ex, nil
+		}), IsNil)
+
+		db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(child))
+		c.Assume(err, IsNil)
+
+		png, err := testFile("dbtesting/image_test.png")
+		c.Assume(err, IsNil)
+		pngBytes, err := ioutil.ReadFile("dbtesting/image_test.png")
+		c.Assume(err, IsNil)
+
+		c.Specify("and executes it with files that are allowed", func() {
+			_, err := db.Execute(MockFileAction{Image: png, MaxSize: 1 << 20})
+			c.Assume(err, IsNil)
+			c.Expect(bytes.Equal(contents, pngBytes), IsTrue)
+		})
+
+		c.Specify("and rejects a type that isn't allowed before executing it", func() {
+			txt, err := testFile("dbtesting/text_test.txt")
+			c.Assume(err, IsNil)
+
+			_, err = db.Execute(MockFileAction{Image: txt})
+			c.Expect(ex.ExecuteWasCalled, IsFalse)
+
+			var ferr *action.FieldError
+			c.Assume(errors.As(err, &ferr), IsTrue)
+			c.Expect(ferr.Field, Equals, "image")
+			c.Expect(ferr.Rule, Equals, "type")
+		})
+
+		c.Specify("and rejects a file larger than the max size as it's read", func() {
+			_, err := db.Execute(MockFileAction{Image: png, MaxSize: 16})
+
+			var ferr *action.FieldError
+			c.Assume(errors.As(err, &ferr), IsTrue)
+			c.Expect(ferr.Field, Equals, "image")
+			c.Expect(ferr.Rule, Equals, "size")
+		})
+	})
+}
@@ -3,19 +3,44 @@ package datatype
 import (
 	"io"
 	"mime/multipart"
+	"time"
 )
 
 type (
 	Id uint64
 )
 
+// The contents of an uploaded file. It's only read once so it
+// may be a stream such as a pipe or an HTTP request's body.
+// One that is also an io.Closer is closed by FormFile.Close.
 type UploadedTempFile interface {
 	io.Reader
-	io.Seeker
-	io.Closer
 }
 
 type FormFile struct {
 	File   UploadedTempFile
 	Header *multipart.FileHeader
+	// The name of the form input it was uploaded with, if it's known
+	Field string
+}
+
+// Closes the file if it can be closed
+func (f FormFile) Close() error {
+	if closer, ok := f.File.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// The metadata of a file saved by a Transaction
+type StoredFile struct {
+	// Only set when the Database keeps a record of the files it stores
+	Id Id
+	// The content addressed name the file is stored under
+	Name string
+	// The name of the file that was uploaded
+	Filename   string
+	Size       int64
+	MimeType   string
+	UploadedAt time.Time
 }
@@ -89,7 +89,7 @@ func describeExecutor(c gospec.Context, input action.A, e ExecutorDescription, c
 		c.Assume(os.RemoveAll(tmp), IsNil)
 	}()
 
-	db, err := database.NewDatabase(testDb, tmp)
+	db, err := database.NewDatabase(testDb, database.NewDirBlobStore(tmp))
 	c.Assume(err, IsNil)
 
 	context := &ExecutorContext{
@@ -1,6 +1,7 @@
 package dbtesting
 
 import (
+	"github.com/ghthor/database"
 	"github.com/ghthor/database/action"
 	"github.com/ghthor/database/config"
 	"github.com/ghthor/gospec"
@@ -45,7 +46,7 @@ func (e *MockExecutor) Describe(c *ExecutorContext) {
 	e.dbWasCreated, err = c.Db.MysqlDatabase().Exists()
 	c.Assume(err, IsNil)
 
-	_, err = os.Open(c.Db.Filepath())
+	_, err = os.Open(c.Db.BlobStore().(*database.DirBlobStore).Dir())
 
 	e.dirWasCreated = (err == nil)
 }
@@ -87,7 +88,7 @@ func DescribeSpecifyExecutor(c gospec.Context) {
 			c.Expect(executor.dirWasCreated, IsTrue)
 
 			c.Specify("and removes it upon completion", func() {
-				_, err = os.Open(executor.c.Db.Filepath())
+				_, err = os.Open(executor.c.Db.BlobStore().(*database.DirBlobStore).Dir())
 				c.Expect(err, Not(IsNil))
 				_, IsPathError := err.(*os.PathError)
 				c.Expect(IsPathError, IsTrue)
@@ -13,8 +13,38 @@ func (e Err) Error() string {
 	return fmt.Sprintf("database error:%s", e.Err.Error())
 }
 
+func (e Err) Unwrap() error { return e.Err }
+
+// Returned by Database.Execute when no executor has been
+// registered for the action's type
+type NoExecutorError struct {
+	Typename string
+}
+
+func (e NoExecutorError) Error() string {
+	return fmt.Sprintf("no executor registered for %s", e.Typename)
+}
+
+// Wraps the error returned by an action's IsValid.
+// errors.Is(err, ErrInvalidAction) is true for an InvalidActionError and
+// errors.As can retrieve an *action.ValidationError from it.
+type InvalidActionError struct {
+	Err error
+}
+
+func (e InvalidActionError) Error() string {
+	return fmt.Sprintf("%s:%s", ErrInvalidAction.Error(), e.Err.Error())
+}
+
+func (e InvalidActionError) Unwrap() error { return e.Err }
+
+func (e InvalidActionError) Is(target error) bool { return target == ErrInvalidAction }
+
 var (
 	ErrUnimplemented     = errors.New("unimplemented")
 	ErrInvalidAction     = errors.New("attempted to execute with an invalid action")
 	ErrResourceForbidden = errors.New("resource forbidden")
+	ErrTxDone            = errors.New("transaction has already been committed or rolled back")
+	ErrNoSavepoint       = errors.New("no such savepoint")
+	ErrTxRolledBack      = errors.New("transaction was rolled back")
 )
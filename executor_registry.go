@@ -1,9 +1,13 @@
 package database
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"github.com/ghthor/database/action"
 	"reflect"
+	"runtime"
+	"sort"
 )
 
 var executorRegistry *ExecutorRegistry
@@ -14,35 +18,231 @@ func init() {
 
 type NewExecutor func(DatabaseConn) (Executor, error)
 
+// Describes an action type that has been bound to an executor constructor
+type ActionBinding struct {
+	// A zero value of the action type that was registered
+	Prototype action.A
+
+	Typename string
+	PkgPath  string
+
+	NewExecutor NewExecutor
+	// The fully qualified name of the NewExecutor func
+	Constructor string
+
+	// The file:line that Register was called from
+	RegisteredAt string
+
+	// Installed with Use
+	Middleware []Middleware
+
+	// Required by the Database's Authorizer
+	Roles []string
+}
+
+// Configures an ActionBinding during Register
+type BindingOption func(*ActionBinding)
+
+func (b ActionBinding) String() string {
+	return fmt.Sprintf("%s (%s) => %s registered at %s", b.Typename, b.PkgPath, b.Constructor, b.RegisteredAt)
+}
+
+// An ExecutorRegistry binds action types to executor constructors.
+// A registry created with NewChild falls back to its parent for any
+// action type it doesn't bind itself and may override the parent's bindings.
 type ExecutorRegistry struct {
-	executors map[string]NewExecutor
+	parent   *ExecutorRegistry
+	bindings map[string]ActionBinding
 }
 
 func NewExecutorRegistry() *ExecutorRegistry {
-	return &ExecutorRegistry{make(map[string]NewExecutor)}
+	return &ExecutorRegistry{nil, make(map[string]ActionBinding)}
+}
+
+// The registry used by RegisterAction and by any Database that
+// wasn't created with a registry of its own
+func DefaultExecutorRegistry() *ExecutorRegistry {
+	return executorRegistry
+}
+
+// Creates an empty registry that falls back to r
+func (r *ExecutorRegistry) NewChild() *ExecutorRegistry {
+	return &ExecutorRegistry{r, make(map[string]ActionBinding)}
 }
 
-func (r *ExecutorRegistry) Register(a action.A, e NewExecutor) error {
-	typename := reflect.TypeOf(a).String()
+func (r *ExecutorRegistry) Parent() *ExecutorRegistry { return r.parent }
 
-	if _, exists := r.executors[typename]; exists {
+func actionTypename(a action.A) string {
+	return reflect.TypeOf(a).String()
+}
+
+func actionPkgPath(a action.A) string {
+	t := reflect.TypeOf(a)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath()
+}
+
+func funcName(fn interface{}) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+		return f.Name()
+	}
+	return "unknown"
+}
+
+func callerSite(skip int) string {
+	_, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+func (r *ExecutorRegistry) Register(a action.A, e NewExecutor, opts ...BindingOption) error {
+	return r.register(a, e, callerSite(1), opts)
+}
+
+func (r *ExecutorRegistry) register(a action.A, e NewExecutor, registeredAt string, opts []BindingOption) error {
+	typename := actionTypename(a)
+
+	if _, exists := r.bindings[typename]; exists {
 		return errors.New("action binding already exists")
 	} else {
-		r.executors[typename] = e
+		binding := ActionBinding{
+			Prototype: a,
+
+			Typename: typename,
+			PkgPath:  actionPkgPath(a),
+
+			NewExecutor: e,
+			Constructor: funcName(e),
+
+			RegisteredAt: registeredAt,
+		}
+
+		for _, opt := range opts {
+			opt(&binding)
+		}
+
+		r.bindings[typename] = binding
 	}
 
 	return nil
 }
 
-func RegisterAction(a action.A, e NewExecutor) error {
-	return executorRegistry.Register(a, e)
+func RegisterAction(a action.A, e NewExecutor, opts ...BindingOption) error {
+	return executorRegistry.register(a, e, callerSite(1), opts)
+}
+
+// Removes the binding for the action's type.
+// A parent's binding is never removed by a child.
+func (r *ExecutorRegistry) Unregister(a action.A) error {
+	typename := actionTypename(a)
+
+	if _, exists := r.bindings[typename]; !exists {
+		return errors.New("action binding doesn't exist")
+	}
+
+	delete(r.bindings, typename)
+	return nil
 }
 
 func (r *ExecutorRegistry) Lookup(a action.A) NewExecutor {
-	typename := reflect.TypeOf(a).String()
-	return r.executors[typename]
+	binding, _ := r.lookup(actionTypename(a))
+	return binding.NewExecutor
+}
+
+func (r *ExecutorRegistry) lookup(typename string) (ActionBinding, bool) {
+	for ; r != nil; r = r.parent {
+		if binding, exists := r.bindings[typename]; exists {
+			return binding, true
+		}
+	}
+	return ActionBinding{}, false
+}
+
+// Every binding visible from this registry keyed by typename
+func (r *ExecutorRegistry) allBindings() map[string]ActionBinding {
+	if r.parent == nil {
+		return r.bindings
+	}
+
+	bindings := r.parent.allBindings()
+	merged := make(map[string]ActionBinding, len(bindings)+len(r.bindings))
+	for typename, binding := range bindings {
+		merged[typename] = binding
+	}
+	for typename, binding := range r.bindings {
+		merged[typename] = binding
+	}
+	return merged
+}
+
+// The bindings, including any inherited from a parent, sorted by typename
+func (r *ExecutorRegistry) Bindings() []ActionBinding {
+	all := r.allBindings()
+
+	bindings := make([]ActionBinding, 0, len(all))
+	for _, binding := range all {
+		bindings = append(bindings, binding)
+	}
+
+	sort.Sort(bindingsByTypename(bindings))
+	return bindings
 }
 
+type bindingsByTypename []ActionBinding
+
+func (b bindingsByTypename) Len() int           { return len(b) }
+func (b bindingsByTypename) Less(i, j int) bool { return b[i].Typename < b[j].Typename }
+func (b bindingsByTypename) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// A prototype of each registered action sorted by typename
 func (r *ExecutorRegistry) RegisteredActions() []action.A {
-	return nil
+	bindings := r.Bindings()
+
+	actions := make([]action.A, 0, len(bindings))
+	for _, binding := range bindings {
+		actions = append(actions, binding.Prototype)
+	}
+	return actions
+}
+
+// A human readable catalogue of the registered actions
+func (r *ExecutorRegistry) Describe() string {
+	buf := &bytes.Buffer{}
+	for _, binding := range r.Bindings() {
+		fmt.Fprintln(buf, binding)
+	}
+	return buf.String()
+}
+
+// The actions registered with RegisterAction
+func RegisteredActions() []action.A {
+	return executorRegistry.RegisteredActions()
+}
+
+// A catalogue of the actions registered with RegisterAction. Only the actions of
+// the packages linked into the running binary have been registered, so this must
+// be called by the binary that serves them.
+func DescribeActions() string {
+	return executorRegistry.Describe()
+}
+
+// Instantiate every registered executor against the DatabaseConn.
+// The result is keyed by the action's typename.
+func (r *ExecutorRegistry) newExecutors(conn DatabaseConn) (map[string]Executor, error) {
+	bindings := r.allBindings()
+	executors := make(map[string]Executor, len(bindings))
+
+	for typename, binding := range bindings {
+		executor, err := binding.NewExecutor(conn)
+		if err != nil {
+			return nil, err
+		}
+		executors[typename] = executor
+	}
+
+	return executors, nil
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/ghthor/gospec"
 	. "github.com/ghthor/gospec"
 	"reflect"
+	"strings"
 )
 
 type (
@@ -69,3 +70,86 @@ func DescribeExecutorRegistry(c gospec.Context) {
 		})
 	})
 }
+
+func DescribeExecutorRegistryIntrospection(c gospec.Context) {
+	c.Specify("An executor registry", func() {
+		r := NewExecutorRegistry()
+
+		c.Assume(r.Register(MockAction2(""), NewMockAction2Ex), IsNil)
+		c.Assume(r.Register(MockAction1(""), NewMockAction1Ex), IsNil)
+
+		c.Specify("can list the registered actions sorted by typename", func() {
+			actions := r.RegisteredActions()
+			c.Assume(len(actions), Equals, 2)
+			c.Expect(actions[0], Equals, action.A(MockAction1("")))
+			c.Expect(actions[1], Equals, action.A(MockAction2("")))
+		})
+
+		c.Specify("retains metadata about each binding", func() {
+			binding := r.Bindings()[0]
+			c.Expect(binding.Typename, Equals, "database.MockAction1")
+			c.Expect(binding.PkgPath, Equals, "github.com/ghthor/database")
+			c.Expect(binding.Constructor, Equals, "github.com/ghthor/database.NewMockAction1Ex")
+			c.Expect(strings.Contains(binding.RegisteredAt, "executor_registry_test.go:"), IsTrue)
+
+			c.Specify("that can be described", func() {
+				c.Expect(strings.Contains(r.Describe(), binding.String()), IsTrue)
+			})
+		})
+
+		c.Specify("can unregister an action", func() {
+			c.Assume(r.Unregister(MockAction1("")), IsNil)
+			c.Expect(r.Lookup(MockAction1("")), IsNil)
+			c.Expect(len(r.RegisteredActions()), Equals, 1)
+
+			c.Specify("and will error if the action isn't registered", func() {
+				c.Expect(r.Unregister(MockAction1("")), Not(IsNil))
+			})
+
+			c.Specify("and can register it again", func() {
+				c.Expect(r.Register(MockAction1(""), NewMockAction2Ex), IsNil)
+			})
+		})
+	})
+}
+
+func DescribeChildExecutorRegistry(c gospec.Context) {
+	c.Specify("A child executor registry", func() {
+		parent := NewExecutorRegistry()
+		c.Assume(parent.Register(MockAction1(""), NewMockAction1Ex), IsNil)
+		c.Assume(parent.Register(MockAction2(""), NewMockAction2Ex), IsNil)
+
+		child := parent.NewChild()
+		c.Expect(child.Parent() == parent, IsTrue)
+
+		c.Specify("falls back to the parent's bindings", func() {
+			c.Expect(child.Lookup(MockAction1("")), Equals, NewExecutor(NewMockAction1Ex))
+			c.Expect(len(child.RegisteredActions()), Equals, 2)
+		})
+
+		c.Specify("can override a parent's binding", func() {
+			c.Assume(child.Register(MockAction1(""), NewMockAction2Ex), IsNil)
+			c.Expect(child.Lookup(MockAction1("")), Equals, NewExecutor(NewMockAction2Ex))
+			c.Expect(len(child.RegisteredActions()), Equals, 2)
+
+			c.Specify("without modifying the parent", func() {
+				c.Expect(parent.Lookup(MockAction1("")), Equals, NewExecutor(NewMockAction1Ex))
+			})
+
+			c.Specify("but will error if overridden twice", func() {
+				c.Expect(child.Register(MockAction1(""), NewMockAction1Ex), Not(IsNil))
+			})
+		})
+
+		c.Specify("can bind actions the parent doesn't know about", func() {
+			c.Assume(child.Register(MockAction3(""), NewMockAction1Ex), IsNil)
+			c.Expect(parent.Lookup(MockAction3("")), IsNil)
+			c.Expect(len(child.RegisteredActions()), Equals, 3)
+		})
+
+		c.Specify("cannot unregister a parent's binding", func() {
+			c.Expect(child.Unregister(MockAction2("")), Not(IsNil))
+			c.Expect(child.Lookup(MockAction2("")), Equals, NewExecutor(NewMockAction2Ex))
+		})
+	})
+}
@@ -1,69 +1,83 @@
 package database
 
 import (
-	"crypto/sha1"
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"github.com/ghthor/database/datatype"
-	"github.com/ziutek/mymysql/mysql"
 	"io"
-	"os"
-	"path/filepath"
-	"strings"
+	"time"
 )
 
-func saveFilesTx(tx mysql.Transaction, files []datatype.FormFile, dir string) (sha1Names []string, err error) {
-	sha1Names, err = saveFiles(files, dir)
+// Uploads are written under this prefix until their hash is known
+const uploadBlobPrefix = ".upload-"
+
+// The file is read once, it's hashed while being written to a
+// temporary blob that is renamed to the hash once it's complete.
+// The extension is derived from the media type detected from its contents.
+func saveFile(formFile datatype.FormFile, store BlobStore, fileHash FileHash, policy FilePolicy) (stored datatype.StoredFile, err error) {
+	file := formFile.File
+
+	h, err := fileHash.New()
 	if err != nil {
-		rollbackErr := tx.Rollback()
-		if rollbackErr != nil {
-			return nil, RollbackError{rollbackErr, err}
-		} else {
-			return nil, err
-		}
+		return
 	}
-	return
-}
 
-func saveFiles(files []datatype.FormFile, dir string) (sha1Names []string, err error) {
-	for _, file := range files {
-		filename, err := saveFile(file, dir)
-		if err != nil {
-			return nil, err
-		}
-		sha1Names = append(sha1Names, filename)
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return
+	}
+	head = head[:n]
+
+	mediaType := sniffMediaType(head)
+	if !policy.allows(mediaType) {
+		return stored, rejectFileType(formField(formFile), formFile, mediaType)
 	}
-	return
-}
 
-func saveFile(formFile datatype.FormFile, dir string) (sha1Name string, err error) {
-	file, header := formFile.File, formFile.Header
+	r := io.MultiReader(bytes.NewReader(head), file)
+	if policy.MaxSize > 0 {
+		r = &maxSizeReader{r, policy.MaxSize}
+	}
 
-	h := sha1.New()
-	_, err = io.Copy(h, file)
+	suffix, err := genSuffix()
 	if err != nil {
 		return
 	}
+	upload := uploadBlobPrefix + suffix
 
-	sha1Name = hex.EncodeToString(h.Sum(nil))
+	var size byteCounter
+	err = store.Put(upload, io.TeeReader(r, io.MultiWriter(h, &size)))
+	if errors.Is(err, errFileTooLarge) {
+		return stored, rejectFileSize(formField(formFile), formFile, policy.MaxSize)
+	} else if err != nil {
+		return
+	}
 
-	// Append the extension
-	parts := strings.Split(header.Filename, ".")
-	sha1Name += "." + parts[len(parts)-1]
+	name := fileHash.filename(hex.EncodeToString(h.Sum(nil)), canonicalExtension(mediaType))
 
-	imgFile, err := os.Create(filepath.Join(dir, sha1Name))
+	err = store.Rename(upload, name)
 	if err != nil {
-		return
+		store.Delete(upload)
+		return stored, err
 	}
-	defer imgFile.Close()
 
-	_, err = file.Seek(0, 0)
-	if err != nil {
-		return
+	stored = datatype.StoredFile{
+		Name:       name,
+		Size:       int64(size),
+		MimeType:   mediaType,
+		UploadedAt: time.Now(),
 	}
-	_, err = io.Copy(imgFile, file)
-	if err != nil {
-		return
+	if formFile.Header != nil {
+		stored.Filename = formFile.Header.Filename
 	}
+	return stored, nil
+}
+
+// Counts the bytes written to it
+type byteCounter int64
 
-	return sha1Name, nil
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
 }
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// The names given to files by SaveFile
+var storedFilename = regexp.MustCompile(`^((?:sha256-|blake2b-)?[0-9a-f]{40,64})(\.[0-9A-Za-z]+)?$`)
+
+// Serves the files saved by Transaction.SaveFile by name.
+// Files are never modified once saved so they are served with a strong
+// ETag of their hash and may be cached forever.
+type fileHandler struct {
+	store BlobStore
+}
+
+// FileHandler serves the files saved by Transaction.SaveFile from the
+// root of the URL path. Use http.StripPrefix to mount it elsewhere.
+// Only names produced by SaveFile are served, anything else,
+// including directories and paths outside of the store, is not found.
+func (c *Database) FileHandler() http.Handler { return c.fileServer }
+
+func (h fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	match := storedFilename.FindStringSubmatch(name)
+	if match == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	info, err := h.store.Stat(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	blob, err := h.store.Get(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer blob.Close()
+
+	contentType := mime.TypeByExtension(match[2])
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("ETag", `"`+match[1]+`"`)
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	http.ServeContent(w, r, name, info.ModTime, blob)
+}
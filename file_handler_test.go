@@ -0,0 +1,119 @@
+package database
+
+import (
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func DescribeFileHandler(c gospec.Context) {
+	tmp, err := ioutil.TempDir("", "file-handler-spec")
+	c.Assume(err, IsNil)
+	defer func() { c.Assume(os.RemoveAll(tmp), IsNil) }()
+
+	db, err := NewDatabase(&MysqlDatabase{}, NewDirBlobStore(tmp), WithExecutorRegistry(NewExecutorRegistry()))
+	c.Assume(err, IsNil)
+
+	png, err := testFile("dbtesting/image_test.png")
+	c.Assume(err, IsNil)
+
+	tx := newTransaction(&MockMysqlTx{}, db.BlobStore())
+	stored, err := tx.SaveFile(png)
+	c.Assume(err, IsNil)
+	c.Assume(tx.Commit(), IsNil)
+	name := stored.Name
+
+	pngBytes, err := ioutil.ReadFile("dbtesting/image_test.png")
+	c.Assume(err, IsNil)
+
+	get := func(path string, headers ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+
+		w := httptest.NewRecorder()
+		db.FileHandler().ServeHTTP(w, r)
+		return w
+	}
+
+	c.Specify("a file handler", func() {
+		c.Specify("serves a saved file", func() {
+			w := get("/" + name)
+			c.Expect(w.Code, Equals, http.StatusOK)
+			c.Expect(w.Body.String(), Equals, string(pngBytes))
+
+			c.Specify("with the content type of its extension", func() {
+				c.Expect(w.Header().Get("Content-Type"), Equals, "image/png")
+			})
+
+			c.Specify("with a strong etag of its hash", func() {
+				c.Expect(w.Header().Get("ETag"), Equals, `"`+name[:40]+`"`)
+
+				c.Specify("that can be revalidated", func() {
+					w := get("/"+name, "If-None-Match", `"`+name[:40]+`"`)
+					c.Expect(w.Code, Equals, http.StatusNotModified)
+				})
+			})
+
+			c.Specify("that may be cached forever", func() {
+				c.Expect(w.Header().Get("Cache-Control"), Equals, "public, max-age=31536000, immutable")
+			})
+		})
+
+		c.Specify("serves a range of a saved file", func() {
+			w := get("/"+name, "Range", "bytes=0-9")
+			c.Expect(w.Code, Equals, http.StatusPartialContent)
+			c.Expect(w.Body.String(), Equals, string(pngBytes[:10]))
+		})
+
+		c.Specify("doesn't list directories", func() {
+			c.Expect(get("/").Code, Equals, http.StatusNotFound)
+		})
+
+		c.Specify("doesn't serve files it didn't save", func() {
+			c.Assume(ioutil.WriteFile(filepath.Join(tmp, "notes.txt"), []byte("notes"), 0644), IsNil)
+			c.Expect(get("/notes.txt").Code, Equals, http.StatusNotFound)
+		})
+
+		c.Specify("doesn't serve files outside of the store", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.URL.Path = "/../" + name
+
+			w := httptest.NewRecorder()
+			db.FileHandler().ServeHTTP(w, r)
+			c.Expect(w.Code, Equals, http.StatusNotFound)
+		})
+
+		c.Specify("doesn't serve a missing file", func() {
+			c.Expect(get("/0000000000000000000000000000000000000000.png").Code, Equals, http.StatusNotFound)
+		})
+
+		c.Specify("serves a file saved with sha256 in a sharded store", func() {
+			db, err := NewDatabase(&MysqlDatabase{}, NewShardedBlobStore(NewMemBlobStore()),
+				WithExecutorRegistry(NewExecutorRegistry()), WithFileHash(SHA256))
+			c.Assume(err, IsNil)
+
+			png, err := testFile("dbtesting/image_test.png")
+			c.Assume(err, IsNil)
+
+			tx := newTransaction(&MockMysqlTx{}, db.BlobStore())
+			tx.fileHash = db.fileHash
+			stored, err := tx.SaveFile(png)
+			c.Assume(err, IsNil)
+			c.Assume(tx.Commit(), IsNil)
+			name := stored.Name
+
+			w := httptest.NewRecorder()
+			db.FileHandler().ServeHTTP(w, httptest.NewRequest("GET", "/"+name, nil))
+			c.Expect(w.Code, Equals, http.StatusOK)
+			c.Expect(w.Header().Get("ETag"), Equals, `"`+strings.TrimSuffix(name, ".png")+`"`)
+			c.Expect(w.Body.String(), Equals, string(pngBytes))
+		})
+	})
+}
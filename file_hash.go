@@ -0,0 +1,67 @@
+package database
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+	"hash"
+	"strings"
+)
+
+// The hash algorithm used to name the files saved by a Transaction
+type FileHash string
+
+const (
+	// Names are the bare hex digest, this is how files were always named
+	SHA1    FileHash = "sha1"
+	SHA256  FileHash = "sha256"
+	BLAKE2b FileHash = "blake2b"
+)
+
+func (h FileHash) New() (hash.Hash, error) {
+	switch h {
+	case "", SHA1:
+		return sha1.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	case BLAKE2b:
+		return blake2b.New256(nil)
+	}
+	return nil, fmt.Errorf("unknown file hash %q", string(h))
+}
+
+// Files hashed with anything other than SHA1 are named with the algorithm as a prefix
+func (h FileHash) prefix() string {
+	if h == "" || h == SHA1 {
+		return ""
+	}
+	return string(h) + "-"
+}
+
+// The name of a file with the digest and extension
+func (h FileHash) filename(digest, ext string) string {
+	return h.prefix() + digest + "." + ext
+}
+
+// The hex digest within a saved file's name
+func fileDigest(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.IndexByte(name, '-'); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// Name the saved files with the hash instead of SHA1
+func WithFileHash(h FileHash) Option {
+	return func(db *Database) error {
+		if _, err := h.New(); err != nil {
+			return err
+		}
+		db.fileHash = h
+		return nil
+	}
+}
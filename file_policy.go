@@ -0,0 +1,303 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ghthor/database/action"
+	"github.com/ghthor/database/datatype"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Restricts the files that may be saved. The zero value allows any file.
+type FilePolicy struct {
+	// The media types, as detected from the contents of a file, that may be saved
+	// such as "image/png" or "image/*" for any image. Every type is allowed if empty.
+	AllowedTypes []string
+	// The largest file in bytes that may be saved, 0 is unlimited
+	MaxSize int64
+}
+
+func (p FilePolicy) allows(mediaType string) bool {
+	if len(p.AllowedTypes) == 0 {
+		return true
+	}
+
+	for _, allowed := range p.AllowedTypes {
+		if allowed == mediaType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// An action that restricts the files it's executed with. Its policy is checked
+// before the executor runs and replaces the Database's for SaveFileContext.
+type FilePolicyAction interface {
+	action.A
+	FilePolicy() FilePolicy
+}
+
+type filePolicyKey struct{}
+
+func withFilePolicy(ctx context.Context, p FilePolicy) context.Context {
+	return context.WithValue(ctx, filePolicyKey{}, p)
+}
+
+func filePolicyFromContext(ctx context.Context) (FilePolicy, bool) {
+	p, ok := ctx.Value(filePolicyKey{}).(FilePolicy)
+	return p, ok
+}
+
+// Restrict the files saved by every transaction
+func WithFilePolicy(p FilePolicy) Option {
+	return func(db *Database) error {
+		db.filePolicy = p
+		return nil
+	}
+}
+
+// The number of bytes http.DetectContentType considers
+const sniffLen = 512
+
+// Detects the media type from the first bytes of a file, without any parameters
+func sniffMediaType(head []byte) string {
+	contentType := http.DetectContentType(head)
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.TrimSpace(contentType)
+}
+
+// The extensions given to files of the types http.DetectContentType detects,
+// mime.ExtensionsByType depends on the system's mime.types and isn't in any order
+var canonicalExtensions = map[string]string{
+	"application/octet-stream":      "bin",
+	"application/ogg":               "ogg",
+	"application/pdf":               "pdf",
+	"application/postscript":        "ps",
+	"application/vnd.ms-fontobject": "eot",
+	"application/wasm":              "wasm",
+	"application/x-gzip":            "gz",
+	"application/x-rar-compressed":  "rar",
+	"application/zip":               "zip",
+	"audio/aiff":                    "aiff",
+	"audio/basic":                   "au",
+	"audio/midi":                    "mid",
+	"audio/mpeg":                    "mp3",
+	"audio/wave":                    "wav",
+	"font/otf":                      "otf",
+	"font/ttf":                      "ttf",
+	"font/woff":                     "woff",
+	"font/woff2":                    "woff2",
+	"image/bmp":                     "bmp",
+	"image/gif":                     "gif",
+	"image/jpeg":                    "jpg",
+	"image/png":                     "png",
+	"image/webp":                    "webp",
+	"image/x-icon":                  "ico",
+	"text/html":                     "html",
+	"text/plain":                    "txt",
+	"text/xml":                      "xml",
+	"video/avi":                     "avi",
+	"video/mp4":                     "mp4",
+	"video/webm":                    "webm",
+}
+
+func canonicalExtension(mediaType string) string {
+	if ext, ok := canonicalExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return strings.TrimPrefix(exts[0], ".")
+	}
+	return "bin"
+}
+
+var errFileTooLarge = errors.New("file too large")
+
+// Fails once more than remaining bytes have been read
+type maxSizeReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, errFileTooLarge
+	}
+
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+// The form input a file was uploaded with, "file" if it isn't known
+func formField(formFile datatype.FormFile) string {
+	if formFile.Field != "" {
+		return formFile.Field
+	}
+	return "file"
+}
+
+// A file uploaded with the form input field that the policy doesn't allow
+func rejectFile(field string, formFile datatype.FormFile, rule, message string) error {
+	var filename string
+	if formFile.Header != nil {
+		filename = formFile.Header.Filename
+	}
+	return &action.FieldError{
+		Field:   field,
+		Str:     filename,
+		Rule:    rule,
+		Message: message,
+		Err:     action.ErrInvalidFilename,
+	}
+}
+
+func rejectFileType(field string, formFile datatype.FormFile, mediaType string) error {
+	return rejectFile(field, formFile, "type", fmt.Sprintf("%s files aren't allowed", mediaType))
+}
+
+func rejectFileSize(field string, formFile datatype.FormFile, maxSize int64) error {
+	return rejectFile(field, formFile, "size", fmt.Sprintf("must not be larger than %d bytes", maxSize))
+}
+
+var formFileType = reflect.TypeOf(datatype.FormFile{})
+
+// Checks the files of a FilePolicyAction against its policy before it's executed,
+// so the policy holds however its executor saves them. The type of each file is
+// checked before the executor runs and its size as the executor reads it.
+// The policy also replaces the Database's for the transactions of the executor
+// that are given the context, see SaveFileContext.
+func enforcingFilePolicy(e Executor) Executor {
+	return ExecutorFunc(func(ctx context.Context, a action.A) (interface{}, error) {
+		pa, ok := a.(FilePolicyAction)
+		if !ok {
+			return ExecuteWithContext(ctx, e, a)
+		}
+
+		policy := pa.FilePolicy()
+		a, err := checkActionFiles(a, policy)
+		if err != nil {
+			return nil, err
+		}
+		return ExecuteWithContext(withFilePolicy(ctx, policy), e, a)
+	})
+}
+
+// Replaces the files of the action with checked files. An action
+// that isn't a pointer is copied so the caller's isn't modified.
+func checkActionFiles(a action.A, policy FilePolicy) (action.A, error) {
+	v := reflect.ValueOf(a)
+	switch {
+	case v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.Struct:
+		return a, checkFileFields("", v.Elem(), policy)
+
+	case v.Kind() == reflect.Struct:
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		if err := checkFileFields("", copied, policy); err != nil {
+			return nil, err
+		}
+		return copied.Interface().(action.A), nil
+	}
+	return a, nil
+}
+
+// Fields are named like action.FromRequest binds them
+func checkFileFields(prefix string, v reflect.Value, policy FilePolicy) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf, field := t.Field(i), v.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		name := sf.Tag.Get("form")
+		if name == "" {
+			name = sf.Name
+		}
+		name = prefix + name
+
+		switch {
+		case sf.Type == formFileType:
+			formFile := field.Interface().(datatype.FormFile)
+			if formFile.File == nil {
+				continue
+			}
+			if formFile.Field == "" {
+				formFile.Field = name
+			}
+
+			checked, err := checkFile(formFile, policy)
+			if err != nil {
+				return err
+			}
+			field.Set(reflect.ValueOf(checked))
+
+		case field.Kind() == reflect.Struct:
+			if err := checkFileFields(name+".", field, policy); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Checks the file's type and limits the bytes that can be read from it
+func checkFile(formFile datatype.FormFile, policy FilePolicy) (datatype.FormFile, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(formFile.File, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return formFile, err
+	}
+	head = head[:n]
+
+	if mediaType := sniffMediaType(head); !policy.allows(mediaType) {
+		return formFile, rejectFileType(formFile.Field, formFile, mediaType)
+	}
+
+	r := io.MultiReader(bytes.NewReader(head), formFile.File)
+	if policy.MaxSize > 0 {
+		r = &maxSizeReader{r, policy.MaxSize}
+	}
+
+	checked := formFile
+	checked.File = checkedFile{r, rejectFileSize(formFile.Field, formFile, policy.MaxSize), formFile.File}
+	return checked, nil
+}
+
+// A file whose size is checked as it's read, it's closed with the file it replaced
+type checkedFile struct {
+	r        io.Reader
+	tooLarge error
+	file     datatype.UploadedTempFile
+}
+
+func (f checkedFile) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == errFileTooLarge {
+		err = f.tooLarge
+	}
+	return n, err
+}
+
+func (f checkedFile) Close() error {
+	return datatype.FormFile{File: f.file}.Close()
+}
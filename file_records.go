@@ -0,0 +1,49 @@
+package database
+
+import "github.com/ziutek/mymysql/mysql"
+
+const (
+	createStoredFilesSql = "CREATE TABLE IF NOT EXISTS `stored_files` (" +
+		"`id` bigint unsigned NOT NULL AUTO_INCREMENT, " +
+		"`name` varchar(255) NOT NULL, " +
+		"`filename` varchar(255) NOT NULL, " +
+		"`size` bigint unsigned NOT NULL, " +
+		"`mime_type` varchar(255) NOT NULL, " +
+		"`uploaded_at` datetime NOT NULL, " +
+		"PRIMARY KEY (`id`), " +
+		"KEY `name` (`name`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8"
+
+	insertStoredFileSql = "INSERT INTO `stored_files` (`name`, `filename`, `size`, `mime_type`, `uploaded_at`) VALUES (?, ?, ?, ?, ?)"
+)
+
+// Records every file that is saved as a row of the stored_files table
+type fileRecords struct {
+	insert mysql.Stmt
+}
+
+// Keep a record of every file that is saved in a stored_files table that
+// is created if it doesn't exist. The row is inserted within the transaction
+// that saves the file and its id is returned as the StoredFile's Id, so
+// executors can reference the upload instead of storing its metadata themselves.
+func WithFileRecords() Option {
+	return func(db *Database) error {
+		conn := db.MysqlConn()
+
+		create, err := conn.Prepare(createStoredFilesSql)
+		if err != nil {
+			return err
+		}
+		if _, err := create.Run(); err != nil {
+			return err
+		}
+
+		records := &fileRecords{}
+		if records.insert, err = conn.Prepare(insertStoredFileSql); err != nil {
+			return err
+		}
+
+		db.fileRecords = records
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"errors"
+	"github.com/ghthor/database/datatype"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"github.com/ziutek/mymysql/mysql"
+	"time"
+)
+
+func DescribeFileRecords(c gospec.Context) {
+	store := NewMemBlobStore()
+	db, stmts, mysqlTx, err := newStmtsDatabase(store, WithFileRecords())
+	c.Assume(err, IsNil)
+
+	c.Specify("a database keeping records of stored files", func() {
+		c.Specify("creates the stored_files table", func() {
+			c.Expect(stmts[createStoredFilesSql].RunWasCalled, IsTrue)
+		})
+
+		tx, err := db.Begin()
+		c.Assume(err, IsNil)
+
+		png, err := testFile("dbtesting/image_test.png")
+		c.Assume(err, IsNil)
+
+		c.Specify("inserts a record within the transaction when a file is saved", func() {
+			var params []interface{}
+			stmts[insertStoredFileSql].RunFunc = func(p ...interface{}) (mysql.Result, error) {
+				params = p
+				return &MockResult{InsertIdNum: 7}, nil
+			}
+
+			stored, err := tx.SaveFile(png)
+			c.Assume(err, IsNil)
+			c.Expect(stored.Id, Equals, datatype.Id(7))
+			c.Expect(mysqlTx.DoWasCalled, IsTrue)
+
+			c.Assume(len(params), Equals, 5)
+			c.Expect(params[0], Equals, stored.Name)
+			c.Expect(params[1], Equals, "image_test.png")
+			c.Expect(params[2], Equals, stored.Size)
+			c.Expect(params[3], Equals, "image/png")
+			c.Expect(params[4].(time.Time).Equal(stored.UploadedAt), IsTrue)
+		})
+
+		c.Specify("rolls back if the record can't be inserted", func() {
+			stmts[insertStoredFileSql].RunFunc = func(...interface{}) (mysql.Result, error) {
+				return nil, errors.New("insert failed")
+			}
+
+			_, err := tx.SaveFile(png)
+			c.Expect(err.Error(), Equals, "insert failed")
+			c.Expect(mysqlTx.RollbackWasCalled, IsTrue)
+
+			names, err := store.List()
+			c.Assume(err, IsNil)
+			c.Expect(len(names), Equals, 0)
+		})
+	})
+}
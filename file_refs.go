@@ -0,0 +1,110 @@
+package database
+
+import (
+	"github.com/ziutek/mymysql/mysql"
+	"sync"
+)
+
+const (
+	createFileRefsSql = "CREATE TABLE IF NOT EXISTS `file_refs` (" +
+		"`name` varchar(255) NOT NULL, " +
+		"`refs` int unsigned NOT NULL DEFAULT 0, " +
+		"PRIMARY KEY (`name`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8"
+
+	addFileRefSql         = "INSERT INTO `file_refs` (`name`, `refs`) VALUES (?, 1) ON DUPLICATE KEY UPDATE `refs` = `refs` + 1"
+	releaseFileRefSql     = "UPDATE `file_refs` SET `refs` = `refs` - 1 WHERE `name` = ? AND `refs` > 0"
+	referencedFileRefsSql = "SELECT `name` FROM `file_refs` WHERE `refs` > 0"
+)
+
+// Counts the references to each saved file in the file_refs table.
+// The counts are updated within the transaction that saves or releases
+// a file so they're only visible once it commits.
+type fileRefs struct {
+	add, release, referenced mysql.Stmt
+
+	// Held for reading by every open transaction and for writing while files
+	// are garbage collected. A transaction holds the connection until it ends,
+	// so it must take this first or the collection would wait on the connection.
+	mu sync.RWMutex
+}
+
+// Track the number of references to each saved file in a file_refs table
+// that is created if it doesn't exist. SaveFile adds a reference and
+// Transaction.ReleaseFile removes one. Database.GarbageCollectFiles
+// deletes the files that aren't referenced, so enabling this on a
+// BlobStore that already has files requires the table to be populated.
+func WithFileRefs() Option {
+	return func(db *Database) error {
+		conn := db.MysqlConn()
+
+		create, err := conn.Prepare(createFileRefsSql)
+		if err != nil {
+			return err
+		}
+		if _, err := create.Run(); err != nil {
+			return err
+		}
+
+		refs := &fileRefs{}
+		if refs.add, err = conn.Prepare(addFileRefSql); err != nil {
+			return err
+		}
+		if refs.release, err = conn.Prepare(releaseFileRefSql); err != nil {
+			return err
+		}
+		if refs.referenced, err = conn.Prepare(referencedFileRefsSql); err != nil {
+			return err
+		}
+
+		db.fileRefs = refs
+		return nil
+	}
+}
+
+// Deletes the saved files that are no longer referenced and returns their names.
+// The Database must have been created WithFileRefs. This waits for the open
+// transactions to end and transactions begun meanwhile wait for it, so it
+// mustn't be called by a goroutine with an open transaction.
+//
+// Only the transactions of this process are waited for. This must not run
+// while another process is using the Database and BlobStore, a file it
+// commits after the references are read would be deleted.
+func (c *Database) GarbageCollectFiles() ([]string, error) {
+	refs := c.fileRefs
+	if refs == nil {
+		return nil, Err{ErrUnimplemented}
+	}
+
+	refs.mu.Lock()
+	defer refs.mu.Unlock()
+
+	rows, _, err := refs.referenced.Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		referenced[row.Str(0)] = true
+	}
+
+	names, err := c.blobStore.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	for _, name := range names {
+		if isInternalBlob(name) || referenced[name] {
+			continue
+		}
+
+		if err := c.blobStore.Delete(name); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, name)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"errors"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"github.com/ziutek/mymysql/mysql"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replaces the connection the Database uses to prepare statements and begin transactions
+func withMysqlConn(conn MymysqlConn) Option {
+	return func(db *Database) error {
+		db.conn = conn
+		return nil
+	}
+}
+
+// Prepares a MockStmt per sql statement
+func newStmtsConn() (*MockMysqlConn, map[string]*MockStmt) {
+	stmts := make(map[string]*MockStmt)
+	return &MockMysqlConn{
+		PrepareFunc: func(sql string) (mysql.Stmt, error) {
+			stmt, exists := stmts[sql]
+			if !exists {
+				stmt = &MockStmt{}
+				stmts[sql] = stmt
+			}
+			return stmt, nil
+		},
+	}, stmts
+}
+
+// A Database on a newStmtsConn that begins every transaction with the same MockMysqlTx
+func newStmtsDatabase(store BlobStore, opts ...Option) (*Database, map[string]*MockStmt, *MockMysqlTx, error) {
+	conn, stmts := newStmtsConn()
+
+	mysqlTx := &MockMysqlTx{}
+	conn.BeginFunc = func() (mysql.Transaction, error) {
+		return mockTransaction{mysqlTx}, nil
+	}
+
+	opts = append([]Option{WithExecutorRegistry(NewExecutorRegistry()), withMysqlConn(conn)}, opts...)
+	db, err := NewDatabase(&MysqlDatabase{}, store, opts...)
+	return db, stmts, mysqlTx, err
+}
+
+func DescribeFileRefs(c gospec.Context) {
+	store := NewMemBlobStore()
+	db, stmts, mysqlTx, err := newStmtsDatabase(store, WithFileRefs())
+	c.Assume(err, IsNil)
+
+	c.Specify("a database tracking file references", func() {
+		c.Specify("creates the file_refs table", func() {
+			c.Expect(stmts[createFileRefsSql].RunWasCalled, IsTrue)
+		})
+
+		tx, err := db.Begin()
+		c.Assume(err, IsNil)
+
+		png, err := testFile("dbtesting/image_test.png")
+		c.Assume(err, IsNil)
+
+		var params []interface{}
+		stmts[addFileRefSql].RunFunc = func(p ...interface{}) (mysql.Result, error) {
+			params = p
+			return &MockResult{}, nil
+		}
+
+		stored, err := tx.SaveFile(png)
+		c.Assume(err, IsNil)
+		name := stored.Name
+
+		c.Specify("adds a reference when a file is saved", func() {
+			c.Expect(len(params), Equals, 1)
+			c.Expect(params[0], Equals, name)
+		})
+
+		c.Specify("removes a reference when a file is released", func() {
+			c.Assume(tx.ReleaseFile(name), IsNil)
+			c.Expect(stmts[releaseFileRefSql].RunWasCalled, IsTrue)
+		})
+
+		c.Specify("rolls back if a reference can't be added", func() {
+			stmts[addFileRefSql].RunFunc = func(...interface{}) (mysql.Result, error) {
+				return nil, errors.New("add failed")
+			}
+
+			txt, err := testFile("dbtesting/text_test.txt")
+			c.Assume(err, IsNil)
+
+			_, err = tx.SaveFile(txt)
+			c.Expect(err.Error(), Equals, "add failed")
+			c.Expect(mysqlTx.RollbackWasCalled, IsTrue)
+
+			names, err := store.List()
+			c.Assume(err, IsNil)
+			c.Expect(len(names), Equals, 0)
+		})
+
+		c.Specify("garbage collects files that aren't referenced", func() {
+			c.Assume(tx.Commit(), IsNil)
+			c.Assume(store.Put("0000000000000000000000000000000000000000.txt", strings.NewReader("orphan")), IsNil)
+
+			stmts[referencedFileRefsSql].ExecFunc = func(...interface{}) ([]mysql.Row, mysql.Result, error) {
+				return []mysql.Row{{[]byte(name)}}, &MockResult{}, nil
+			}
+
+			deleted, err := db.GarbageCollectFiles()
+			c.Assume(err, IsNil)
+			c.Expect(strings.Join(deleted, ","), Equals, "0000000000000000000000000000000000000000.txt")
+
+			names, err := store.List()
+			c.Assume(err, IsNil)
+			c.Expect(strings.Join(names, ","), Equals, name)
+		})
+
+		c.Specify("doesn't garbage collect files that are staged", func() {
+			// Staged by a transaction that doesn't hold the lock garbage collection waits on
+			staging := newTransaction(&MockMysqlTx{}, store)
+			_, err := staging.SaveFile(textFile("staged"))
+			c.Assume(err, IsNil)
+			c.Assume(tx.Rollback(), IsNil)
+
+			deleted, err := db.GarbageCollectFiles()
+			c.Assume(err, IsNil)
+			c.Expect(len(deleted), Equals, 0)
+		})
+
+		c.Specify("garbage collects after an open transaction commits", func() {
+			// Like thrsafe, an open transaction holds the connection
+			// and running a statement outside of it waits for it to end
+			connMu := &sync.Mutex{}
+			connMu.Lock()
+			mysqlTx.CommitFunc = func() error {
+				connMu.Unlock()
+				return nil
+			}
+
+			committed := false
+			stmts[referencedFileRefsSql].ExecFunc = func(...interface{}) ([]mysql.Row, mysql.Result, error) {
+				connMu.Lock()
+				defer connMu.Unlock()
+				if committed {
+					return []mysql.Row{{[]byte(name)}}, &MockResult{}, nil
+				}
+				return nil, &MockResult{}, nil
+			}
+
+			collected := make(chan []string, 1)
+			go func() {
+				deleted, _ := db.GarbageCollectFiles()
+				collected <- deleted
+			}()
+
+			// Give the collection a chance to start before committing
+			time.Sleep(10 * time.Millisecond)
+			committed = true
+			c.Assume(tx.Commit(), IsNil)
+
+			var deleted []string
+			deadlocked := false
+			select {
+			case deleted = <-collected:
+			case <-time.After(time.Second):
+				deadlocked = true
+			}
+			c.Assume(deadlocked, IsFalse)
+			c.Expect(len(deleted), Equals, 0)
+
+			_, err := store.Stat(name)
+			c.Expect(err, IsNil)
+		})
+	})
+
+	c.Specify("a database that isn't tracking file references can't garbage collect", func() {
+		db, err := NewDatabase(&MysqlDatabase{}, store, WithExecutorRegistry(NewExecutorRegistry()))
+		c.Assume(err, IsNil)
+
+		_, err = db.GarbageCollectFiles()
+		c.Expect(errors.Is(err, ErrUnimplemented), IsTrue)
+	})
+}
+
+// Adapts a MockMysqlTx to the mysql.Transaction returned by Begin
+type mockTransaction struct {
+	*MockMysqlTx
+}
+
+func (mockTransaction) Prepare(string) (mysql.Stmt, error) { return nil, nil }
+func (mockTransaction) Ping() error                        { return nil }
+func (mockTransaction) ThreadId() uint32                   { return 0 }
+func (mockTransaction) Escape(txt string) string           { return txt }
+func (mockTransaction) IsValid() bool                      { return true }
+
+func (mockTransaction) Query(string, ...interface{}) ([]mysql.Row, mysql.Result, error) {
+	return nil, nil, nil
+}
+func (mockTransaction) QueryFirst(string, ...interface{}) (mysql.Row, mysql.Result, error) {
+	return nil, nil, nil
+}
+func (mockTransaction) QueryLast(string, ...interface{}) (mysql.Row, mysql.Result, error) {
+	return nil, nil, nil
+}
@@ -1,10 +1,16 @@
 package database
 
 import (
+	"errors"
+	"github.com/ghthor/database/action"
 	"github.com/ghthor/database/datatype"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"io"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func testFile(filepathStr string) (datatype.FormFile, error) {
@@ -20,3 +26,168 @@ func testFile(filepathStr string) (datatype.FormFile, error) {
 		},
 	}, nil
 }
+
+func DescribeSaveFile(c gospec.Context) {
+	store := NewMemBlobStore()
+
+	c.Specify("saving a file", func() {
+		c.Specify("names it by the sha1 of its contents", func() {
+			file, err := testFile("dbtesting/text_test.txt")
+			c.Assume(err, IsNil)
+
+			stored, err := saveFile(file, store, SHA1, FilePolicy{})
+			c.Assume(err, IsNil)
+			c.Expect(stored.Name, Equals, "1833207066f2835d021b9dc165b0485a06dcd6ce.txt")
+		})
+
+		c.Specify("returns its metadata", func() {
+			file, err := testFile("dbtesting/image_test.png")
+			c.Assume(err, IsNil)
+
+			info, err := os.Stat("dbtesting/image_test.png")
+			c.Assume(err, IsNil)
+
+			stored, err := saveFile(file, store, SHA1, FilePolicy{})
+			c.Assume(err, IsNil)
+			c.Expect(stored.Filename, Equals, "image_test.png")
+			c.Expect(stored.Size, Equals, info.Size())
+			c.Expect(stored.MimeType, Equals, "image/png")
+			c.Expect(stored.UploadedAt.IsZero(), IsFalse)
+			c.Expect(stored.Id, Equals, datatype.Id(0))
+		})
+
+		c.Specify("names it with the algorithm when it isn't sha1", func() {
+			file, err := testFile("dbtesting/text_test.txt")
+			c.Assume(err, IsNil)
+
+			stored, err := saveFile(file, store, SHA256, FilePolicy{})
+			c.Assume(err, IsNil)
+			c.Expect(stored.Name, Equals, "sha256-ee268254fcb0d0cbc2e86a9bce1313e7622eb7397937e34f9432a907c707ad37.txt")
+
+			file, err = testFile("dbtesting/text_test.txt")
+			c.Assume(err, IsNil)
+
+			stored, err = saveFile(file, store, BLAKE2b, FilePolicy{})
+			c.Assume(err, IsNil)
+			c.Expect(stored.Name, Equals, "blake2b-468b7e4773b5db520007c200d2cf8484934a4fe5b978ceb85ebef456e256c5df.txt")
+		})
+
+		c.Specify("fails with an unknown algorithm", func() {
+			file, err := testFile("dbtesting/text_test.txt")
+			c.Assume(err, IsNil)
+
+			_, err = saveFile(file, store, FileHash("md5"), FilePolicy{})
+			c.Expect(err, Not(IsNil))
+		})
+
+		c.Specify("names it with the extension of the type detected from its contents", func() {
+			file, err := testFile("dbtesting/image_test.png")
+			c.Assume(err, IsNil)
+			file.Header.Filename = "image.txt"
+
+			stored, err := saveFile(file, store, SHA1, FilePolicy{})
+			c.Assume(err, IsNil)
+			c.Expect(strings.HasSuffix(stored.Name, ".png"), IsTrue)
+
+			file = datatype.FormFile{
+				File:   strings.NewReader("no extension"),
+				Header: &multipart.FileHeader{Filename: "README"},
+			}
+
+			stored, err = saveFile(file, store, SHA1, FilePolicy{})
+			c.Assume(err, IsNil)
+			c.Expect(strings.HasSuffix(stored.Name, ".txt"), IsTrue)
+		})
+
+		c.Specify("with a policy", func() {
+			policy := FilePolicy{AllowedTypes: []string{"image/*"}, MaxSize: 1 << 20}
+
+			c.Specify("allows a file it matches", func() {
+				file, err := testFile("dbtesting/image_test.png")
+				c.Assume(err, IsNil)
+
+				_, err = saveFile(file, store, SHA1, policy)
+				c.Expect(err, IsNil)
+			})
+
+			c.Specify("rejects a type that isn't allowed", func() {
+				file, err := testFile("dbtesting/text_test.txt")
+				c.Assume(err, IsNil)
+				file.Header.Filename = "image.png"
+
+				_, err = saveFile(file, store, SHA1, policy)
+				c.Expect(errors.Is(err, action.ErrInvalidFilename), IsTrue)
+
+				var ferr *action.FieldError
+				c.Assume(errors.As(err, &ferr), IsTrue)
+				c.Expect(ferr.Rule, Equals, "type")
+				c.Expect(ferr.Str, Equals, "image.png")
+			})
+
+			c.Specify("rejects a file larger than the max size", func() {
+				file, err := testFile("dbtesting/image_test.png")
+				c.Assume(err, IsNil)
+
+				policy.MaxSize = 16
+				_, err = saveFile(file, store, SHA1, policy)
+				c.Expect(errors.Is(err, action.ErrInvalidFilename), IsTrue)
+
+				var ferr *action.FieldError
+				c.Assume(errors.As(err, &ferr), IsTrue)
+				c.Expect(ferr.Rule, Equals, "size")
+
+				names, err := store.List()
+				c.Assume(err, IsNil)
+				c.Expect(len(names), Equals, 0)
+			})
+
+			c.Specify("allows a file of exactly the max size", func() {
+				file := datatype.FormFile{
+					File:   strings.NewReader("GIF89a"),
+					Header: &multipart.FileHeader{Filename: "tiny.gif"},
+				}
+
+				policy.MaxSize = 6
+				stored, err := saveFile(file, store, SHA1, policy)
+				c.Assume(err, IsNil)
+				c.Expect(strings.HasSuffix(stored.Name, ".gif"), IsTrue)
+			})
+		})
+
+		c.Specify("reads it from a stream", func() {
+			r, w := io.Pipe()
+			go func() {
+				w.Write([]byte("streamed "))
+				w.Write([]byte("contents"))
+				w.Close()
+			}()
+
+			file := datatype.FormFile{
+				File:   r,
+				Header: &multipart.FileHeader{Filename: "stream.txt"},
+			}
+
+			stored, err := saveFile(file, store, SHA1, FilePolicy{})
+			c.Assume(err, IsNil)
+			c.Expect(stored.Name, Equals, "c49ad8dea70575e69eddd8e16680fdbfee0a8c9e.txt")
+
+			names, err := store.List()
+			c.Assume(err, IsNil)
+			c.Expect(strings.Join(names, ","), Equals, stored.Name)
+		})
+
+		c.Specify("doesn't keep anything if reading fails", func() {
+			file := datatype.FormFile{
+				File:   io.MultiReader(strings.NewReader("partial"), failingReader{}),
+				Header: &multipart.FileHeader{Filename: "failing.txt"},
+			}
+
+			_, err := saveFile(file, store, SHA1, FilePolicy{})
+			c.Expect(err, Not(IsNil))
+
+			names, err := store.List()
+			c.Assume(err, IsNil)
+			c.Expect(len(names), Equals, 0)
+		})
+	})
+}
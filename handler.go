@@ -0,0 +1,164 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ghthor/database/action"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const actionsPath = "/actions/"
+
+type actionHandler struct {
+	db      *Database
+	actions map[string]reflect.Type
+}
+
+// Handler exposes the actions registered with the Database as JSON endpoints.
+//
+// POST /actions/{Name} decodes the request into a new instance of the action
+// type named Name, using action.Bind if the action is a struct, and executes
+// it with the request's context. Input that can't be decoded is reported like
+// an invalid action once the action has been authorized. Actions from
+// different packages that share a Name are ambiguous and can't be routed to.
+//
+// The result is encoded as {"result": ...} and an error as {"error": "..."} with
+// the invalid fields of an *action.ValidationError listed in "fields". The status is
+// 400 for an invalid action, 403 for ErrResourceForbidden, 501 for ErrUnimplemented
+// and 500 for any other error, which is logged instead of being sent to the client.
+func Handler(db *Database) http.Handler {
+	h := &actionHandler{db, make(map[string]reflect.Type)}
+
+	ambiguous := make(map[string]bool)
+	for _, binding := range db.ExecutorRegistry().Bindings() {
+		t := reflect.TypeOf(binding.Prototype)
+
+		name := t.Name()
+		if t.Kind() == reflect.Ptr {
+			name = t.Elem().Name()
+		}
+
+		if _, exists := h.actions[name]; exists || ambiguous[name] {
+			ambiguous[name] = true
+			delete(h.actions, name)
+			continue
+		}
+		h.actions[name] = t
+	}
+
+	return h
+}
+
+type fieldErrorResponse struct {
+	Field   string `json:"field"`
+	Input   string `json:"input"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type errorResponse struct {
+	Error  string               `json:"error"`
+	Fields []fieldErrorResponse `json:"fields,omitempty"`
+}
+
+type resultResponse struct {
+	Result interface{} `json:"result"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+
+	var verr *action.ValidationError
+	var ferr *action.FieldError
+	switch {
+	case errors.As(err, &verr) || errors.Is(err, ErrInvalidAction):
+		status = http.StatusBadRequest
+	case errors.As(err, &ferr):
+		verr = &action.ValidationError{Fields: []*action.FieldError{ferr}}
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrResourceForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrUnimplemented):
+		status = http.StatusNotImplemented
+	}
+
+	res := errorResponse{Error: err.Error()}
+	if status == http.StatusInternalServerError {
+		// The error may reveal details such as MySQL errors or file paths
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		res.Error = http.StatusText(status)
+	}
+	if verr != nil {
+		for _, f := range verr.Fields {
+			res.Fields = append(res.Fields, fieldErrorResponse{f.Field, f.Str, f.Rule, f.Message})
+		}
+	}
+
+	writeJSON(w, status, res)
+}
+
+func (h *actionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, actionsPath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	t, exists := h.actions[strings.TrimPrefix(r.URL.Path, actionsPath)]
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// ptr is what the request is decoded into and a is what will be executed,
+	// they differ when the action was registered as a value type
+	var ptr reflect.Value
+	var a func() action.A
+	if t.Kind() == reflect.Ptr {
+		ptr = reflect.New(t.Elem())
+		a = func() action.A { return ptr.Interface().(action.A) }
+	} else {
+		ptr = reflect.New(t)
+		a = func() action.A { return ptr.Elem().Interface().(action.A) }
+	}
+
+	ctx := r.Context()
+	if target, ok := ptr.Interface().(action.A); ok && ptr.Elem().Kind() == reflect.Struct {
+		// The uploaded files are only needed until the action has been executed
+		defer func() {
+			action.CloseFiles(target)
+			if r.MultipartForm != nil {
+				r.MultipartForm.RemoveAll()
+			}
+		}()
+
+		// The action is validated when it's executed, after it has been authorized
+		if err := action.Bind(r, target); err != nil {
+			ctx = withBindError(ctx, err)
+		}
+	} else if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, action.MaxJSONBodySize)).Decode(ptr.Interface()); err != nil {
+		ctx = withBindError(ctx, err)
+	}
+
+	res, err := h.db.ExecuteContext(ctx, a())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resultResponse{res})
+}
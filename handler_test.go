@@ -0,0 +1,152 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ghthor/database/action"
+	"github.com/ghthor/database/datatype"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+type MockCreateAction struct {
+	Id   action.Id `json:"id" form:"id"`
+	Name string    `json:"name" form:"name" action:"required"`
+}
+
+func (a *MockCreateAction) IsValid() error { return action.Validate(a) }
+
+type MockValueAction struct {
+	Name string `form:"name"`
+}
+
+func (MockValueAction) IsValid() error { return nil }
+
+func DescribeHandler(c gospec.Context) {
+	ex := &MockExecutor{
+		ExecuteFunc: func(a action.A) (interface{}, error) {
+			switch a := a.(type) {
+			case *MockCreateAction:
+				return map[string]interface{}{"id": a.Id.Native, "name": a.Name}, nil
+			case MockValueAction:
+				return a.Name, nil
+			case MockValidAction:
+				return nil, errors.New(string(a))
+			}
+			return nil, nil
+		},
+	}
+	newEx := func(DatabaseConn) (Executor, error) { return ex, nil }
+
+	r := NewExecutorRegistry()
+	c.Assume(r.Register(&MockCreateAction{}, newEx), IsNil)
+	c.Assume(r.Register(MockValueAction{}, newEx), IsNil)
+	c.Assume(r.Register(MockValidAction(""), newEx), IsNil)
+	c.Assume(r.Register(MockPrincipalAction{}, newEx, RequireRoles("admin")), IsNil)
+
+	db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(r), WithAuthorizer(RoleAuthorizer{}))
+	c.Assume(err, IsNil)
+
+	h := Handler(db)
+
+	post := func(path, contentType, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		var res map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w, res
+	}
+
+	c.Specify("an actions handler", func() {
+		c.Specify("executes an action decoded from a JSON body", func() {
+			w, res := post("/actions/MockCreateAction", "application/json", `{"id": "7", "name": "a name"}`)
+			c.Expect(w.Code, Equals, http.StatusOK)
+			c.Expect(w.Header().Get("Content-Type"), Equals, "application/json; charset=utf-8")
+			c.Expect(res["result"] != nil, IsTrue)
+			c.Expect(w.Body.String(), Equals, `{"result":{"id":7,"name":"a name"}}`+"\n")
+		})
+
+		c.Specify("executes an action decoded from a form", func() {
+			w, res := post("/actions/MockValueAction", "application/x-www-form-urlencoded", "name=value")
+			c.Expect(w.Code, Equals, http.StatusOK)
+			c.Expect(res["result"], Equals, "value")
+		})
+
+		c.Specify("executes an action that isn't a struct", func() {
+			w, res := post("/actions/MockValidAction", "application/json", `"failed"`)
+			c.Expect(w.Code, Equals, http.StatusInternalServerError)
+			c.Expect(res["error"], Equals, "Internal Server Error")
+		})
+
+		c.Specify("responds with the invalid fields of an invalid action", func() {
+			w, res := post("/actions/MockCreateAction", "application/json", `{"id": "seven"}`)
+			c.Expect(w.Code, Equals, http.StatusBadRequest)
+
+			fields, _ := res["fields"].([]interface{})
+			c.Assume(len(fields), Equals, 2)
+			idField := fields[0].(map[string]interface{})
+			c.Expect(idField["field"], Equals, "Id")
+			c.Expect(idField["input"], Equals, "seven")
+			c.Expect(idField["rule"], Equals, "parse")
+			c.Expect(idField["message"], Equals, "invalid id")
+			c.Expect(fields[1].(map[string]interface{})["field"], Equals, "Name")
+		})
+
+		c.Specify("responds with the field of a rejected file", func() {
+			ex.ExecuteFunc = func(action.A) (interface{}, error) {
+				return nil, rejectFileType("file", datatype.FormFile{}, "text/html")
+			}
+
+			w, res := post("/actions/MockValueAction", "application/json", `{}`)
+			c.Expect(w.Code, Equals, http.StatusBadRequest)
+
+			fields, _ := res["fields"].([]interface{})
+			c.Assume(len(fields), Equals, 1)
+			c.Expect(fields[0].(map[string]interface{})["rule"], Equals, "type")
+		})
+
+		c.Specify("responds with forbidden", func() {
+			w, _ := post("/actions/MockPrincipalAction", "application/json", `{}`)
+			c.Expect(w.Code, Equals, http.StatusForbidden)
+
+			c.Specify("before reporting input that can't be bound", func() {
+				w, res := post("/actions/MockPrincipalAction", "application/json", `{"MockValidAction": 7}`)
+				c.Expect(w.Code, Equals, http.StatusForbidden)
+				c.Expect(res["fields"], IsNil)
+			})
+		})
+
+		c.Specify("rejects a JSON body that's too large", func() {
+			name := strings.Repeat("a", action.MaxJSONBodySize)
+			w, _ := post("/actions/MockCreateAction", "application/json", `{"name": "`+name+`"}`)
+			c.Expect(w.Code, Equals, http.StatusBadRequest)
+			c.Expect(ex.ExecuteWasCalled, IsFalse)
+		})
+
+		c.Specify("responds with not implemented", func() {
+			ex.ExecuteFunc = func(action.A) (interface{}, error) { return nil, Err{ErrUnimplemented} }
+
+			w, _ := post("/actions/MockValueAction", "application/json", `{}`)
+			c.Expect(w.Code, Equals, http.StatusNotImplemented)
+		})
+
+		c.Specify("responds with not found for an unknown action", func() {
+			w, _ := post("/actions/MockUnboundAction", "application/json", `{}`)
+			c.Expect(w.Code, Equals, http.StatusNotFound)
+		})
+
+		c.Specify("only accepts POST", func() {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/actions/MockValueAction", nil))
+			c.Expect(w.Code, Equals, http.StatusMethodNotAllowed)
+			c.Expect(w.Header().Get("Allow"), Equals, "POST")
+		})
+	})
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/ghthor/database/action"
+)
+
+// A Middleware wraps an Executor with cross-cutting behavior
+// such as logging, timing or retries.
+// The Executor returned should implement ContextExecutor, ExecutorFunc
+// makes this easy, or the context will not reach the executors it wraps.
+type Middleware func(next Executor) Executor
+
+// Adapts a func into a ContextExecutor
+type ExecutorFunc func(context.Context, action.A) (interface{}, error)
+
+func (f ExecutorFunc) ExecuteWith(a action.A) (interface{}, error) {
+	return f(context.Background(), a)
+}
+
+func (f ExecutorFunc) ExecuteWithContext(ctx context.Context, a action.A) (interface{}, error) {
+	return f(ctx, a)
+}
+
+// Executes the action with the context if the Executor is a ContextExecutor
+func ExecuteWithContext(ctx context.Context, e Executor, a action.A) (interface{}, error) {
+	if e, ok := e.(ContextExecutor); ok {
+		return e.ExecuteWithContext(ctx, a)
+	}
+	return e.ExecuteWith(a)
+}
+
+// The first middleware will be the outermost
+func chainMiddleware(e Executor, middleware []Middleware) Executor {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		e = middleware[i](e)
+	}
+	return e
+}
+
+type bindErrorKey struct{}
+
+// Attaches the error from binding the action's input, it's reported as the
+// action's validation error so it isn't revealed before the action is authorized
+func withBindError(ctx context.Context, err error) context.Context {
+	return context.WithValue(ctx, bindErrorKey{}, err)
+}
+
+// Checks the action is valid before dispatching it to the executor
+func validating(e Executor) Executor {
+	return ExecutorFunc(func(ctx context.Context, a action.A) (interface{}, error) {
+		if err, _ := ctx.Value(bindErrorKey{}).(error); err != nil {
+			return nil, InvalidActionError{err}
+		}
+		if err := a.IsValid(); err != nil {
+			return nil, InvalidActionError{err}
+		}
+		return ExecuteWithContext(ctx, e, a)
+	})
+}
+
+// Installs middleware around every action executed by the Database.
+// It runs outside of any middleware installed for an action type
+// with ExecutorRegistry.Use.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(db *Database) error {
+		db.middleware = append(db.middleware, middleware...)
+		return nil
+	}
+}
+
+// Installs middleware around the execution of an action type bound in this registry
+func (r *ExecutorRegistry) Use(a action.A, middleware ...Middleware) error {
+	typename := actionTypename(a)
+
+	binding, exists := r.bindings[typename]
+	if !exists {
+		return errors.New("action binding doesn't exist")
+	}
+
+	binding.Middleware = append(binding.Middleware[:len(binding.Middleware):len(binding.Middleware)], middleware...)
+	r.bindings[typename] = binding
+	return nil
+}
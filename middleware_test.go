@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/ghthor/database/action"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"strings"
+)
+
+func DescribeMiddleware(c gospec.Context) {
+	var calls []string
+
+	recorder := func(name string) Middleware {
+		return func(next Executor) Executor {
+			return ExecutorFunc(func(ctx context.Context, a action.A) (interface{}, error) {
+				calls = append(calls, name)
+				return ExecuteWithContext(ctx, next, a)
+			})
+		}
+	}
+
+	ex := &MockExecutor{
+		ExecuteFunc: func(action.A) (interface{}, error) {
+			calls = append(calls, "executor")
+			return nil, nil
+		},
+	}
+
+	r := NewExecutorRegistry()
+	c.Assume(r.Register(MockValidAction(""), func(DatabaseConn) (Executor, error) { return ex, nil }), IsNil)
+	c.Assume(r.Register(MockInvalidAction(""), func(DatabaseConn) (Executor, error) { return ex, nil }), IsNil)
+
+	c.Specify("middleware", func() {
+		c.Assume(r.Use(MockValidAction(""), recorder("action 1"), recorder("action 2")), IsNil)
+
+		db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(r),
+			WithMiddleware(recorder("database 1")),
+			WithMiddleware(recorder("database 2")),
+		)
+		c.Assume(err, IsNil)
+
+		c.Specify("is executed in the order it was installed with the database's middleware first", func() {
+			_, err := db.Execute(MockValidAction(""))
+			c.Assume(err, IsNil)
+			c.Expect(strings.Join(calls, ", "), Equals, "database 1, database 2, action 1, action 2, executor")
+		})
+
+		c.Specify("installed for an action type is only executed for that type", func() {
+			_, err := db.Execute(MockInvalidAction(""))
+			c.Expect(err, Not(IsNil))
+			c.Expect(strings.Join(calls, ", "), Equals, "database 1, database 2")
+		})
+
+		c.Specify("is executed around the validity check", func() {
+			_, err := db.Execute(MockInvalidAction(""))
+			c.Expect(errors.Is(err, ErrInvalidAction), IsTrue)
+			c.Expect(ex.ExecuteWasCalled, IsFalse)
+		})
+
+		c.Specify("can short circuit execution", func() {
+			denied := errors.New("denied")
+			c.Assume(r.Use(MockInvalidAction(""), func(Executor) Executor {
+				return ExecutorFunc(func(context.Context, action.A) (interface{}, error) {
+					return nil, denied
+				})
+			}), IsNil)
+
+			db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(r))
+			c.Assume(err, IsNil)
+
+			_, err = db.Execute(MockInvalidAction(""))
+			c.Expect(err, Equals, denied)
+		})
+	})
+
+	c.Specify("middleware can't be installed for an unbound action", func() {
+		c.Expect(r.Use(MockUnboundAction(""), recorder("")), Not(IsNil))
+	})
+
+	c.Specify("middleware installed in a parent registry", func() {
+		c.Assume(r.Use(MockValidAction(""), recorder("parent")), IsNil)
+		child := r.NewChild()
+
+		c.Specify("is used by a child that inherits the binding", func() {
+			db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(child))
+			c.Assume(err, IsNil)
+
+			_, err = db.Execute(MockValidAction(""))
+			c.Assume(err, IsNil)
+			c.Expect(strings.Join(calls, ", "), Equals, "parent, executor")
+		})
+
+		c.Specify("is not used by a child that overrides the binding", func() {
+			c.Assume(child.Register(MockValidAction(""), func(DatabaseConn) (Executor, error) { return ex, nil }), IsNil)
+
+			db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(), WithExecutorRegistry(child))
+			c.Assume(err, IsNil)
+
+			_, err = db.Execute(MockValidAction(""))
+			c.Assume(err, IsNil)
+			c.Expect(strings.Join(calls, ", "), Equals, "executor")
+		})
+	})
+}
@@ -33,6 +33,12 @@ func (c *MockMysqlConn) Begin() (mysql.Transaction, error) {
 type MockStmt struct {
 	RunWasCalled bool
 	RunFunc      func(...interface{}) (mysql.Result, error)
+
+	ExecWasCalled bool
+	ExecFunc      func(...interface{}) ([]mysql.Row, mysql.Result, error)
+
+	DeleteWasCalled bool
+	DeleteFunc      func() error
 }
 
 func (s *MockStmt) Bind(params ...interface{}) {}
@@ -46,8 +52,14 @@ func (s *MockStmt) Run(params ...interface{}) (mysql.Result, error) {
 	}
 	return nil, nil
 }
-func (s *MockStmt) Delete() error { return nil }
-func (s *MockStmt) Reset() error  { return nil }
+func (s *MockStmt) Delete() error {
+	s.DeleteWasCalled = true
+	if s.DeleteFunc != nil {
+		return s.DeleteFunc()
+	}
+	return nil
+}
+func (s *MockStmt) Reset() error { return nil }
 
 func (s *MockStmt) SendLongData(pnum int, data interface{}, pkt_size int) error {
 	return nil
@@ -59,6 +71,10 @@ func (s *MockStmt) NumParam() int          { return 0 }
 func (s *MockStmt) WarnCount() int         { return 0 }
 
 func (s *MockStmt) Exec(params ...interface{}) ([]mysql.Row, mysql.Result, error) {
+	s.ExecWasCalled = true
+	if s.ExecFunc != nil {
+		return s.ExecFunc(params...)
+	}
 	return nil, nil, nil
 }
 func (s *MockStmt) ExecFirst(params ...interface{}) (mysql.Row, mysql.Result, error) {
@@ -69,29 +85,56 @@ func (s *MockStmt) ExecLast(params ...interface{}) (mysql.Row, mysql.Result, err
 }
 
 type MockResult struct {
-	MessageStr string
+	MessageStr  string
+	InsertIdNum uint64
+
+	// The names of the columns and the rows returned by GetRows
+	Columns []string
+	Rows    []mysql.Row
+	// Further results, each is ended when it's read
+	More []*MockResult
+
+	EndWasCalled bool
 }
 
 func (r *MockResult) StatusOnly() bool           { return false }
 func (r *MockResult) ScanRow(mysql.Row) error    { return nil }
 func (r *MockResult) GetRow() (mysql.Row, error) { return nil, nil }
 
-func (r *MockResult) MoreResults() bool                 { return false }
-func (r *MockResult) NextResult() (mysql.Result, error) { return nil, nil }
+func (r *MockResult) MoreResults() bool { return len(r.More) > 0 }
+func (r *MockResult) NextResult() (mysql.Result, error) {
+	if len(r.More) == 0 {
+		return nil, nil
+	}
+	next := r.More[0]
+	next.More = r.More[1:]
+	return next, nil
+}
 
 func (r *MockResult) Fields() []*mysql.Field { return nil }
-func (r *MockResult) Map(string) int         { return 0 }
-func (r *MockResult) Message() string        { return r.MessageStr }
-func (r *MockResult) AffectedRows() uint64   { return 0 }
-func (r *MockResult) InsertId() uint64       { return 0 }
-func (r *MockResult) WarnCount() int         { return 0 }
+func (r *MockResult) Map(name string) int {
+	for i, column := range r.Columns {
+		if column == name {
+			return i
+		}
+	}
+	return -1
+}
+func (r *MockResult) Message() string      { return r.MessageStr }
+func (r *MockResult) AffectedRows() uint64 { return 0 }
+func (r *MockResult) InsertId() uint64     { return r.InsertIdNum }
+func (r *MockResult) WarnCount() int       { return 0 }
 
 func (r *MockResult) MakeRow() mysql.Row              { return nil }
-func (r *MockResult) GetRows() ([]mysql.Row, error)   { return nil, nil }
-func (r *MockResult) End() error                      { return nil }
+func (r *MockResult) GetRows() ([]mysql.Row, error)   { return r.Rows, nil }
 func (r *MockResult) GetFirstRow() (mysql.Row, error) { return nil, nil }
 func (r *MockResult) GetLastRow() (mysql.Row, error)  { return nil, nil }
 
+func (r *MockResult) End() error {
+	r.EndWasCalled = true
+	return nil
+}
+
 func DescribeMockMysqlConn(c gospec.Context) {
 	// Compile time Verify interface implementation
 	var _ MymysqlConn = &MockMysqlConn{}
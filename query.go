@@ -0,0 +1,205 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/ziutek/mymysql/mysql"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Reads every row of the result and any further results,
+// the connection is locked until they've all been read
+func readAll(res mysql.Result) ([]mysql.Row, error) {
+	rows, err := res.GetRows()
+	if err != nil {
+		return nil, err
+	}
+	return rows, endMoreResults(res)
+}
+
+// Reads and discards the rest of the result and any further results
+func discardResult(res mysql.Result) error {
+	if err := res.End(); err != nil {
+		return err
+	}
+	return endMoreResults(res)
+}
+
+func endMoreResults(res mysql.Result) error {
+	for more := res; more.MoreResults(); {
+		var err error
+		if more, err = more.NextResult(); err != nil {
+			return err
+		}
+		if more == nil {
+			break
+		}
+		if err = more.End(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs the statement and reads all of its rows
+func (t *transaction) Query(s mysql.Stmt, params ...interface{}) ([]mysql.Row, mysql.Result, error) {
+	res, err := t.RunContext(context.Background(), s, params...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := readAll(res)
+	if err != nil {
+		return nil, nil, t.abort(err)
+	}
+	return rows, res, nil
+}
+
+// Runs the statement and returns its first row, or nil if it has none
+func (t *transaction) QueryFirst(s mysql.Stmt, params ...interface{}) (mysql.Row, mysql.Result, error) {
+	rows, res, err := t.Query(s, params...)
+	if err != nil || len(rows) == 0 {
+		return nil, res, err
+	}
+	return rows[0], res, nil
+}
+
+// Runs a statement that doesn't return any rows, discarding any it does
+func (t *transaction) Exec(s mysql.Stmt, params ...interface{}) (mysql.Result, error) {
+	_, res, err := t.Query(s, params...)
+	return res, err
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// The column a field is read from is named by its db tag, otherwise it's
+// the field's name or its name in snake_case, "UploadedAt" is "uploaded_at"
+func columnIndex(res mysql.Result, field reflect.StructField) int {
+	if tag := field.Tag.Get("db"); tag != "" {
+		if tag == "-" {
+			return -1
+		}
+		return res.Map(tag)
+	}
+
+	if i := res.Map(field.Name); i >= 0 {
+		return i
+	}
+	return res.Map(snakeCase(field.Name))
+}
+
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Fills the exported fields of the struct dst points to from the row's columns.
+// Fields of embedded structs are filled as if they were fields of dst.
+// A field without a column or with a NULL column is left unchanged.
+func ScanStruct(res mysql.Result, row mysql.Row, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ScanStruct requires a pointer to a struct, not %T", dst)
+	}
+	return scanStruct(res, row, v.Elem())
+}
+
+func scanStruct(res mysql.Result, row mysql.Row, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := scanStruct(res, row, v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		col := columnIndex(res, field)
+		if col < 0 || col >= len(row) || row[col] == nil {
+			continue
+		}
+
+		if err := scanColumn(row, col, v.Field(i)); err != nil {
+			return fmt.Errorf("scanning %s: %v", field.Name, err)
+		}
+	}
+	return nil
+}
+
+func scanColumn(row mysql.Row, col int, f reflect.Value) error {
+	if f.Type() == timeType {
+		val, err := row.TimeErr(col, time.Local)
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(val))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(row.Str(col))
+
+	case reflect.Bool:
+		val, err := row.BoolErr(col)
+		if err != nil {
+			return err
+		}
+		f.SetBool(val)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		val, err := row.Int64Err(col)
+		if err != nil {
+			return err
+		}
+		if f.OverflowInt(val) {
+			return fmt.Errorf("%d overflows %s", val, f.Type())
+		}
+		f.SetInt(val)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val, err := row.Uint64Err(col)
+		if err != nil {
+			return err
+		}
+		if f.OverflowUint(val) {
+			return fmt.Errorf("%d overflows %s", val, f.Type())
+		}
+		f.SetUint(val)
+
+	case reflect.Float32, reflect.Float64:
+		val, err := row.FloatErr(col)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(val)
+
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %s", f.Type())
+		}
+		f.SetBytes(row.Bin(col))
+
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
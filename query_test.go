@@ -0,0 +1,115 @@
+package database
+
+import (
+	"errors"
+	"github.com/ghthor/database/datatype"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"github.com/ziutek/mymysql/mysql"
+	"time"
+)
+
+type scannedBase struct {
+	Id datatype.Id
+}
+
+type scannedFile struct {
+	scannedBase
+	Name       string
+	Original   string `db:"filename"`
+	Size       int64
+	MimeType   string
+	UploadedAt time.Time
+	Ignored    string `db:"-"`
+	Missing    string
+}
+
+func DescribeQuery(c gospec.Context) {
+	tx := newTransaction(&MockMysqlTx{}, NewMemBlobStore())
+
+	uploadedAt := time.Date(2016, 5, 4, 3, 2, 1, 0, time.Local)
+	second := &MockResult{}
+	res := &MockResult{
+		Columns: []string{"Id", "name", "filename", "size", "mime_type", "uploaded_at", "Ignored"},
+		Rows: []mysql.Row{
+			{uint64(7), []byte("a.png"), []byte("image.png"), int64(1024), []byte("image/png"), uploadedAt, []byte("x")},
+			{uint64(8), []byte("b.txt"), nil, int64(3), []byte("text/plain"), uploadedAt, nil},
+		},
+		More: []*MockResult{second},
+	}
+	stmt := &MockStmt{
+		RunFunc: func(...interface{}) (mysql.Result, error) { return res, nil },
+	}
+
+	c.Specify("a query in a transaction", func() {
+		c.Specify("returns every row", func() {
+			rows, _, err := tx.Query(stmt)
+			c.Assume(err, IsNil)
+			c.Expect(len(rows), Equals, 2)
+		})
+
+		c.Specify("reads every result", func() {
+			_, err := tx.Exec(stmt)
+			c.Assume(err, IsNil)
+			c.Expect(second.EndWasCalled, IsTrue)
+		})
+
+		c.Specify("can return only the first row", func() {
+			row, _, err := tx.QueryFirst(stmt)
+			c.Assume(err, IsNil)
+			c.Expect(row.Str(1), Equals, "a.png")
+
+			res.Rows = nil
+			row, _, err = tx.QueryFirst(stmt)
+			c.Assume(err, IsNil)
+			c.Expect(row == nil, IsTrue)
+		})
+
+		c.Specify("rolls back if the statement fails", func() {
+			stmt.RunFunc = func(...interface{}) (mysql.Result, error) { return nil, errors.New("query failed") }
+
+			_, _, err := tx.Query(stmt)
+			c.Expect(err.Error(), Equals, "query failed")
+			c.Expect(tx.tx.(*MockMysqlTx).RollbackWasCalled, IsTrue)
+		})
+	})
+
+	c.Specify("a scanned struct", func() {
+		rows, res, err := tx.Query(stmt)
+		c.Assume(err, IsNil)
+
+		var file scannedFile
+		file.Missing = "unchanged"
+		c.Assume(ScanStruct(res, rows[0], &file), IsNil)
+
+		c.Specify("is filled by the name of its fields", func() {
+			c.Expect(file.Id, Equals, datatype.Id(7))
+			c.Expect(file.Name, Equals, "a.png")
+			c.Expect(file.Size, Equals, int64(1024))
+			c.Expect(file.MimeType, Equals, "image/png")
+			c.Expect(file.UploadedAt.Equal(uploadedAt), IsTrue)
+		})
+
+		c.Specify("is filled by the db tags of its fields", func() {
+			c.Expect(file.Original, Equals, "image.png")
+			c.Expect(file.Ignored, Equals, "")
+		})
+
+		c.Specify("leaves fields without a column or with a null column unchanged", func() {
+			c.Expect(file.Missing, Equals, "unchanged")
+
+			c.Assume(ScanStruct(res, rows[1], &file), IsNil)
+			c.Expect(file.Original, Equals, "image.png")
+			c.Expect(file.Name, Equals, "b.txt")
+		})
+
+		c.Specify("must be a pointer to a struct", func() {
+			c.Expect(ScanStruct(res, rows[0], file), Not(IsNil))
+		})
+
+		c.Specify("fails if a column can't be converted", func() {
+			var bad struct{ Name int }
+			c.Expect(ScanStruct(res, rows[0], &bad), Not(IsNil))
+		})
+	})
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/ziutek/mymysql/mysql"
+	"math/rand"
+	"time"
+)
+
+// Controls how InTxRetry runs a transaction again after it failed
+// because of a deadlock or a lock wait timeout
+type RetryPolicy struct {
+	// The most times the transaction is run, including the first
+	MaxAttempts int
+	// The wait after the first attempt, it's doubled after each attempt up to
+	// MaxDelay. Each wait is a random duration between half of the delay and all of it.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   10 * time.Millisecond,
+	MaxDelay:    time.Second,
+}
+
+// Replace the DefaultRetryPolicy used by InTxRetry
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(db *Database) error {
+		db.retryPolicy = p
+		return nil
+	}
+}
+
+// The time to wait after the attempt failed
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Reports if err is a MySQL server error caused by another transaction holding
+// a lock, a transaction that fails because of this may succeed if it's run again.
+// A failed rollback is never retryable.
+func IsRetryable(err error) bool {
+	var rollbackErr RollbackError
+	if errors.As(err, &rollbackErr) {
+		return false
+	}
+
+	code, ok := mysqlErrorCode(err)
+	if !ok {
+		return false
+	}
+
+	return code == mysql.ER_LOCK_DEADLOCK || code == mysql.ER_LOCK_WAIT_TIMEOUT
+}
+
+// The code of the MySQL server error in err's chain
+func mysqlErrorCode(err error) (uint16, bool) {
+	var perr *mysql.Error
+	var verr mysql.Error
+	switch {
+	case errors.As(err, &perr):
+		return perr.Code, true
+	case errors.As(err, &verr):
+		return verr.Code, true
+	}
+	return 0, false
+}
+
+// Runs fn with InTx and runs it again, after a backoff, if it fails with a
+// retryable error until the RetryPolicy's MaxAttempts. Each attempt uses a new
+// transaction and the files saved by a failed attempt are removed, so fn must
+// be able to run from the start again. An uploaded file that's saved must be
+// rewound, or read again, before it's saved by the next attempt.
+// The number of attempts that were made is returned.
+func (c *Database) InTxRetry(ctx context.Context, fn func(Transaction) (interface{}, error)) (result interface{}, attempts int, err error) {
+	policy := c.retryPolicy
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, attempts, err
+		}
+
+		attempts++
+		result, err = c.InTx(fn)
+		if err == nil || !IsRetryable(err) || attempts >= policy.MaxAttempts {
+			return result, attempts, err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempts))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, attempts, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"github.com/ziutek/mymysql/mysql"
+	"time"
+)
+
+func DescribeRetry(c gospec.Context) {
+	deadlock := &mysql.Error{Code: mysql.ER_LOCK_DEADLOCK, Msg: []byte("Deadlock found")}
+	lockWait := &mysql.Error{Code: mysql.ER_LOCK_WAIT_TIMEOUT, Msg: []byte("Lock wait timeout exceeded")}
+
+	c.Specify("deadlocks and lock wait timeouts are retryable", func() {
+		c.Expect(IsRetryable(deadlock), IsTrue)
+		c.Expect(IsRetryable(lockWait), IsTrue)
+		c.Expect(IsRetryable(*lockWait), IsTrue)
+		c.Expect(IsRetryable(InvalidActionError{deadlock}), IsTrue)
+
+		c.Expect(IsRetryable(&mysql.Error{Code: mysql.ER_DUP_ENTRY}), IsFalse)
+		c.Expect(IsRetryable(errors.New("deadlock")), IsFalse)
+		c.Expect(IsRetryable(RollbackError{errors.New("rollback failed"), deadlock}), IsFalse)
+	})
+
+	c.Specify("the backoff grows exponentially up to the max delay", func() {
+		policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+		for attempt, max := range map[int]time.Duration{
+			1: 10 * time.Millisecond,
+			2: 20 * time.Millisecond,
+			3: 40 * time.Millisecond,
+			4: 50 * time.Millisecond,
+			9: 50 * time.Millisecond,
+		} {
+			delay := policy.backoff(attempt)
+			c.Expect(delay >= max/2 && delay <= max, IsTrue)
+		}
+
+		c.Expect(RetryPolicy{}.backoff(3), Equals, time.Duration(0))
+	})
+
+	conn, _ := newStmtsConn()
+
+	var mysqlTxs []*MockMysqlTx
+	conn.BeginFunc = func() (mysql.Transaction, error) {
+		mysqlTx := &MockMysqlTx{}
+		mysqlTxs = append(mysqlTxs, mysqlTx)
+		return mockTransaction{mysqlTx}, nil
+	}
+
+	store := NewMemBlobStore()
+	db, err := NewDatabase(&MysqlDatabase{}, store,
+		WithExecutorRegistry(NewExecutorRegistry()),
+		withMysqlConn(conn),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3}),
+	)
+	c.Assume(err, IsNil)
+
+	c.Specify("a retried transaction", func() {
+		c.Specify("is run again after a deadlock", func() {
+			calls := 0
+			res, attempts, err := db.InTxRetry(context.Background(), func(tx Transaction) (interface{}, error) {
+				calls++
+				if _, err := tx.SaveFile(textFile("attempt")); err != nil {
+					return nil, err
+				}
+				if calls == 1 {
+					return nil, deadlock
+				}
+				return "committed", nil
+			})
+			c.Assume(err, IsNil)
+			c.Expect(res, Equals, "committed")
+			c.Expect(attempts, Equals, 2)
+
+			c.Assume(len(mysqlTxs), Equals, 2)
+			c.Expect(mysqlTxs[0].RollbackWasCalled, IsTrue)
+			c.Expect(mysqlTxs[1].CommitWasCalled, IsTrue)
+
+			c.Specify("removing the files saved by the failed attempt", func() {
+				names, err := store.List()
+				c.Assume(err, IsNil)
+				c.Expect(len(names), Equals, 1)
+			})
+		})
+
+		c.Specify("is run again after a statement deadlocks even if the error is ignored", func() {
+			calls := 0
+			stmt := &MockStmt{
+				RunFunc: func(...interface{}) (mysql.Result, error) {
+					if calls == 1 {
+						return nil, deadlock
+					}
+					return &MockResult{}, nil
+				},
+			}
+
+			_, attempts, err := db.InTxRetry(context.Background(), func(tx Transaction) (interface{}, error) {
+				calls++
+				tx.Run(stmt)
+				return "committed", nil
+			})
+			c.Assume(err, IsNil)
+			c.Expect(attempts, Equals, 2)
+		})
+
+		c.Specify("gives up after the max attempts", func() {
+			_, attempts, err := db.InTxRetry(context.Background(), func(Transaction) (interface{}, error) {
+				return nil, lockWait
+			})
+			c.Expect(err, Equals, error(lockWait))
+			c.Expect(attempts, Equals, 3)
+		})
+
+		c.Specify("isn't run again after an error that isn't retryable", func() {
+			_, attempts, err := db.InTxRetry(context.Background(), func(Transaction) (interface{}, error) {
+				return nil, errors.New("failed")
+			})
+			c.Expect(err.Error(), Equals, "failed")
+			c.Expect(attempts, Equals, 1)
+		})
+
+		c.Specify("isn't run with a cancelled context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, attempts, err := db.InTxRetry(ctx, func(Transaction) (interface{}, error) {
+				return nil, nil
+			})
+			c.Expect(err, Equals, context.Canceled)
+			c.Expect(attempts, Equals, 0)
+		})
+	})
+}
@@ -0,0 +1,238 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/ghthor/database/datatype"
+	"github.com/ziutek/mymysql/mysql"
+	"regexp"
+)
+
+// Savepoint names are written into the statement so they're restricted to identifiers
+var savepointName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+type savepoint struct {
+	name string
+	// The number of files that had been saved when it was created
+	savedFiles int
+}
+
+// Returns the index of the savepoint or -1 if it doesn't exist
+func (t *transaction) findSavepoint(name string) int {
+	for i := len(t.savepoints) - 1; i >= 0; i-- {
+		if t.savepoints[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *transaction) startSavepointSql(sql, name string) error {
+	if _, err := t.tx.Start(sql + " `" + name + "`"); err != nil {
+		return t.abort(err)
+	}
+	return nil
+}
+
+// Creating a savepoint with the name of an existing one replaces it
+func (t *transaction) Savepoint(name string) error {
+	if t.done {
+		return ErrTxDone
+	}
+
+	if !savepointName.MatchString(name) {
+		return fmt.Errorf("invalid savepoint name %q", name)
+	}
+
+	if err := t.startSavepointSql("SAVEPOINT", name); err != nil {
+		return err
+	}
+
+	if i := t.findSavepoint(name); i >= 0 {
+		t.savepoints = append(t.savepoints[:i], t.savepoints[i+1:]...)
+	}
+	t.savepoints = append(t.savepoints, savepoint{name, len(t.savedFiles)})
+	return nil
+}
+
+// Undoes the statements run and deletes the files saved since the savepoint was
+// created. The savepoint is kept but any created after it are removed.
+func (t *transaction) RollbackTo(name string) error {
+	if t.done {
+		return ErrTxDone
+	}
+
+	i := t.findSavepoint(name)
+	if i < 0 {
+		return ErrNoSavepoint
+	}
+
+	if err := t.startSavepointSql("ROLLBACK TO SAVEPOINT", name); err != nil {
+		return err
+	}
+
+	// A file that was saved before the savepoint is only recorded once,
+	// so one saved again after it isn't among the files that are discarded
+	mark := t.savepoints[i].savedFiles
+	if err := discardFiles(t.blobStore, t.id, t.savedFiles[mark:]); err != nil {
+		return t.abort(err)
+	}
+
+	t.savedFiles = t.savedFiles[:mark]
+	t.savepoints = t.savepoints[:i+1]
+	return nil
+}
+
+// Removes the savepoint and any created after it, the files saved since are kept
+func (t *transaction) Release(name string) error {
+	if t.done {
+		return ErrTxDone
+	}
+
+	i := t.findSavepoint(name)
+	if i < 0 {
+		return ErrNoSavepoint
+	}
+
+	if err := t.startSavepointSql("RELEASE SAVEPOINT", name); err != nil {
+		return err
+	}
+
+	t.savepoints = t.savepoints[:i]
+	return nil
+}
+
+func (t *transaction) Begin() (Transaction, error) {
+	if t.done {
+		return nil, ErrTxDone
+	}
+
+	t.nested++
+	name := fmt.Sprintf("nested_%d", t.nested)
+	if err := t.Savepoint(name); err != nil {
+		return nil, err
+	}
+
+	return &nestedTransaction{t, name, false}, nil
+}
+
+// A transaction within a transaction. Committing it releases its savepoint
+// and rolling it back rolls back to the savepoint, the changes made within it
+// are only committed with the outer transaction. A statement that fails still
+// rolls back the outer transaction.
+type nestedTransaction struct {
+	outer     *transaction
+	savepoint string
+
+	done bool
+}
+
+func (n *nestedTransaction) Commit() error {
+	if n.done {
+		return ErrTxDone
+	}
+	n.done = true
+
+	return n.outer.Release(n.savepoint)
+}
+
+func (n *nestedTransaction) Rollback() error {
+	if n.done {
+		return ErrTxDone
+	}
+	n.done = true
+
+	if err := n.outer.RollbackTo(n.savepoint); err != nil {
+		return err
+	}
+	return n.outer.Release(n.savepoint)
+}
+
+func (n *nestedTransaction) Run(s mysql.Stmt, params ...interface{}) (mysql.Result, error) {
+	return n.RunContext(context.Background(), s, params...)
+}
+
+func (n *nestedTransaction) RunContext(ctx context.Context, s mysql.Stmt, params ...interface{}) (mysql.Result, error) {
+	if n.done {
+		return nil, ErrTxDone
+	}
+	return n.outer.RunContext(ctx, s, params...)
+}
+
+func (n *nestedTransaction) Query(s mysql.Stmt, params ...interface{}) ([]mysql.Row, mysql.Result, error) {
+	if n.done {
+		return nil, nil, ErrTxDone
+	}
+	return n.outer.Query(s, params...)
+}
+
+func (n *nestedTransaction) QueryFirst(s mysql.Stmt, params ...interface{}) (mysql.Row, mysql.Result, error) {
+	if n.done {
+		return nil, nil, ErrTxDone
+	}
+	return n.outer.QueryFirst(s, params...)
+}
+
+func (n *nestedTransaction) Exec(s mysql.Stmt, params ...interface{}) (mysql.Result, error) {
+	if n.done {
+		return nil, ErrTxDone
+	}
+	return n.outer.Exec(s, params...)
+}
+
+func (n *nestedTransaction) SaveFile(formFile datatype.FormFile) (datatype.StoredFile, error) {
+	return n.SaveFileContext(context.Background(), formFile)
+}
+
+func (n *nestedTransaction) SaveFileContext(ctx context.Context, formFile datatype.FormFile) (datatype.StoredFile, error) {
+	if n.done {
+		return datatype.StoredFile{}, ErrTxDone
+	}
+	return n.outer.SaveFileContext(ctx, formFile)
+}
+
+func (n *nestedTransaction) SaveFiles(formFiles []datatype.FormFile) ([]datatype.StoredFile, error) {
+	return n.SaveFilesContext(context.Background(), formFiles)
+}
+
+func (n *nestedTransaction) SaveFilesContext(ctx context.Context, formFiles []datatype.FormFile) ([]datatype.StoredFile, error) {
+	if n.done {
+		return nil, ErrTxDone
+	}
+	return n.outer.SaveFilesContext(ctx, formFiles)
+}
+
+func (n *nestedTransaction) ReleaseFile(filename string) error {
+	if n.done {
+		return ErrTxDone
+	}
+	return n.outer.ReleaseFile(filename)
+}
+
+func (n *nestedTransaction) Savepoint(name string) error {
+	if n.done {
+		return ErrTxDone
+	}
+	return n.outer.Savepoint(name)
+}
+
+func (n *nestedTransaction) RollbackTo(name string) error {
+	if n.done {
+		return ErrTxDone
+	}
+	return n.outer.RollbackTo(name)
+}
+
+func (n *nestedTransaction) Release(name string) error {
+	if n.done {
+		return ErrTxDone
+	}
+	return n.outer.Release(name)
+}
+
+func (n *nestedTransaction) Begin() (Transaction, error) {
+	if n.done {
+		return nil, ErrTxDone
+	}
+	return n.outer.Begin()
+}
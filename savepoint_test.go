@@ -0,0 +1,167 @@
+package database
+
+import (
+	"errors"
+	"github.com/ghthor/database/datatype"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"github.com/ziutek/mymysql/mysql"
+	"mime/multipart"
+	"os"
+	"strings"
+)
+
+func textFile(contents string) datatype.FormFile {
+	return datatype.FormFile{
+		File:   strings.NewReader(contents),
+		Header: &multipart.FileHeader{Filename: "file.txt"},
+	}
+}
+
+func DescribeSavepoints(c gospec.Context) {
+	store := NewMemBlobStore()
+	mysqlTx := &MockMysqlTx{}
+	tx := newTransaction(mysqlTx, store)
+
+	before, err := tx.SaveFile(textFile("before"))
+	c.Assume(err, IsNil)
+
+	isStaged := func(name string) bool {
+		_, err := store.Stat(pendingPrefix(tx.id) + name)
+		return err == nil
+	}
+
+	c.Specify("a savepoint", func() {
+		c.Assume(tx.Savepoint("sp"), IsNil)
+		c.Expect(strings.Join(mysqlTx.Started, ";"), Equals, "SAVEPOINT `sp`")
+
+		after, err := tx.SaveFile(textFile("after"))
+		c.Assume(err, IsNil)
+
+		c.Specify("can be rolled back to", func() {
+			c.Assume(tx.RollbackTo("sp"), IsNil)
+			c.Expect(mysqlTx.Started[len(mysqlTx.Started)-1], Equals, "ROLLBACK TO SAVEPOINT `sp`")
+
+			c.Specify("deleting only the files saved after it", func() {
+				c.Expect(isStaged(before.Name), IsTrue)
+				c.Expect(isStaged(after.Name), IsFalse)
+
+				c.Assume(tx.Commit(), IsNil)
+				names, err := store.List()
+				c.Assume(err, IsNil)
+				c.Expect(strings.Join(names, ","), Equals, before.Name)
+			})
+
+			c.Specify("more than once", func() {
+				c.Expect(tx.RollbackTo("sp"), IsNil)
+			})
+		})
+
+		c.Specify("keeps a file saved before it that is saved again after it", func() {
+			_, err := tx.SaveFile(textFile("before"))
+			c.Assume(err, IsNil)
+
+			c.Assume(tx.RollbackTo("sp"), IsNil)
+			c.Expect(isStaged(before.Name), IsTrue)
+		})
+
+		c.Specify("removes the savepoints created after it when rolled back to", func() {
+			c.Assume(tx.Savepoint("later"), IsNil)
+			c.Assume(tx.RollbackTo("sp"), IsNil)
+			c.Expect(tx.RollbackTo("later"), Equals, ErrNoSavepoint)
+		})
+
+		c.Specify("can be released keeping the files saved after it", func() {
+			c.Assume(tx.Release("sp"), IsNil)
+			c.Expect(mysqlTx.Started[len(mysqlTx.Started)-1], Equals, "RELEASE SAVEPOINT `sp`")
+			c.Expect(isStaged(after.Name), IsTrue)
+			c.Expect(tx.RollbackTo("sp"), Equals, ErrNoSavepoint)
+		})
+
+		c.Specify("rolls back the transaction if the statement fails", func() {
+			mysqlTx.StartFunc = func(string) (mysql.Result, error) {
+				return nil, errors.New("rollback to failed")
+			}
+
+			c.Expect(tx.RollbackTo("sp").Error(), Equals, "rollback to failed")
+			c.Expect(mysqlTx.RollbackWasCalled, IsTrue)
+			c.Expect(isStaged(before.Name), IsFalse)
+		})
+	})
+
+	c.Specify("a savepoint that doesn't exist can't be rolled back to or released", func() {
+		c.Expect(tx.RollbackTo("missing"), Equals, ErrNoSavepoint)
+		c.Expect(tx.Release("missing"), Equals, ErrNoSavepoint)
+		c.Expect(len(mysqlTx.Started), Equals, 0)
+	})
+
+	c.Specify("a savepoint must be named by an identifier", func() {
+		c.Expect(tx.Savepoint("sp`; DROP TABLE users"), Not(IsNil))
+		c.Expect(len(mysqlTx.Started), Equals, 0)
+		c.Expect(mysqlTx.RollbackWasCalled, IsFalse)
+	})
+
+	c.Specify("a nested transaction", func() {
+		nested, err := tx.Begin()
+		c.Assume(err, IsNil)
+		c.Expect(strings.Join(mysqlTx.Started, ";"), Equals, "SAVEPOINT `nested_1`")
+
+		inner, err := nested.SaveFile(textFile("inner"))
+		c.Assume(err, IsNil)
+
+		c.Specify("releases its savepoint when committed", func() {
+			c.Assume(nested.Commit(), IsNil)
+			c.Expect(mysqlTx.Started[len(mysqlTx.Started)-1], Equals, "RELEASE SAVEPOINT `nested_1`")
+			c.Expect(isStaged(inner.Name), IsTrue)
+			c.Expect(mysqlTx.CommitWasCalled, IsFalse)
+		})
+
+		c.Specify("rolls back to its savepoint when rolled back", func() {
+			c.Assume(nested.Rollback(), IsNil)
+			c.Expect(strings.Join(mysqlTx.Started[1:], ";"), Equals,
+				"ROLLBACK TO SAVEPOINT `nested_1`;RELEASE SAVEPOINT `nested_1`")
+			c.Expect(isStaged(inner.Name), IsFalse)
+			c.Expect(isStaged(before.Name), IsTrue)
+			c.Expect(mysqlTx.RollbackWasCalled, IsFalse)
+		})
+
+		c.Specify("can't be used once it's finished", func() {
+			c.Assume(nested.Commit(), IsNil)
+			c.Expect(nested.Rollback(), Equals, ErrTxDone)
+
+			_, err := nested.SaveFile(textFile("late"))
+			c.Expect(err, Equals, ErrTxDone)
+
+			_, err = tx.SaveFile(textFile("outer"))
+			c.Expect(err, IsNil)
+		})
+
+		c.Specify("can begin another nested transaction", func() {
+			innermost, err := nested.Begin()
+			c.Assume(err, IsNil)
+
+			_, err = innermost.SaveFile(textFile("innermost"))
+			c.Assume(err, IsNil)
+			c.Assume(innermost.Rollback(), IsNil)
+
+			c.Expect(isStaged(inner.Name), IsTrue)
+			c.Expect(len(tx.savedFiles), Equals, 2)
+		})
+
+		c.Specify("is committed with the outer transaction", func() {
+			c.Assume(nested.Commit(), IsNil)
+			c.Assume(tx.Commit(), IsNil)
+
+			_, err := store.Stat(inner.Name)
+			c.Expect(err, IsNil)
+		})
+
+		c.Specify("is rolled back with the outer transaction", func() {
+			c.Assume(tx.Rollback(), IsNil)
+
+			_, err := store.Stat(inner.Name)
+			c.Expect(os.IsNotExist(err), IsTrue)
+			c.Expect(nested.Commit(), Equals, ErrTxDone)
+		})
+	})
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Stores each saved file in directories named by the first two pairs of hex
+// digits of its digest, "sha256-abcdef...png" is kept as "ab/cd/sha256-abcdef...png",
+// so no directory grows too large. Names are unchanged by the layout and
+// files still in the flat layout, at the root of the store, are found by
+// their name until they're moved with Migrate.
+type ShardedBlobStore struct {
+	BlobStore
+}
+
+func NewShardedBlobStore(store BlobStore) *ShardedBlobStore {
+	return &ShardedBlobStore{store}
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// The name of a saved file in the underlying store.
+// Blobs that are already in a directory, like those staged by a transaction, aren't moved.
+func shardedName(name string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+
+	digest := fileDigest(name)
+	if len(digest) < 4 || !isHex(digest) {
+		return name
+	}
+	return digest[:2] + "/" + digest[2:4] + "/" + name
+}
+
+// The inverse of shardedName
+func unshardedName(name string) string {
+	parts := strings.Split(name, "/")
+	if len(parts) == 3 && shardedName(parts[2]) == name {
+		return parts[2]
+	}
+	return name
+}
+
+func (s *ShardedBlobStore) Put(name string, r io.Reader) error {
+	return s.BlobStore.Put(shardedName(name), r)
+}
+
+func (s *ShardedBlobStore) Get(name string) (Blob, error) {
+	blob, err := s.BlobStore.Get(shardedName(name))
+	if os.IsNotExist(err) {
+		return s.BlobStore.Get(name)
+	}
+	return blob, err
+}
+
+// Deletes both the sharded and the flat copy of a file
+func (s *ShardedBlobStore) Delete(name string) error {
+	sharded := shardedName(name)
+	err := s.BlobStore.Delete(sharded)
+	if sharded == name || (err != nil && !os.IsNotExist(err)) {
+		return err
+	}
+
+	flatErr := s.BlobStore.Delete(name)
+	if err == nil && os.IsNotExist(flatErr) {
+		return nil
+	}
+	return flatErr
+}
+
+func (s *ShardedBlobStore) Rename(from, to string) error {
+	err := s.BlobStore.Rename(shardedName(from), shardedName(to))
+	if os.IsNotExist(err) {
+		return s.BlobStore.Rename(from, shardedName(to))
+	}
+	return err
+}
+
+func (s *ShardedBlobStore) Stat(name string) (BlobInfo, error) {
+	info, err := s.BlobStore.Stat(shardedName(name))
+	if os.IsNotExist(err) {
+		info, err = s.BlobStore.Stat(name)
+	}
+	info.Name = unshardedName(info.Name)
+	return info, err
+}
+
+func (s *ShardedBlobStore) List() ([]string, error) {
+	all, err := s.BlobStore.List()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(all))
+	seen := make(map[string]bool, len(all))
+	for _, name := range all {
+		name = unshardedName(name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *ShardedBlobStore) Sweep() error {
+	if sweeper, ok := s.BlobStore.(interface {
+		Sweep() error
+	}); ok {
+		return sweeper.Sweep()
+	}
+	return nil
+}
+
+// Moves the files in the flat layout into their directories and returns their names.
+// This must not run while another process is using the BlobStore.
+func (s *ShardedBlobStore) Migrate() ([]string, error) {
+	names, err := s.BlobStore.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var moved []string
+	for _, name := range names {
+		sharded := shardedName(name)
+		if sharded == name {
+			continue
+		}
+
+		if err := s.BlobStore.Rename(name, sharded); err != nil {
+			return moved, err
+		}
+		moved = append(moved, name)
+	}
+
+	return moved, nil
+}
@@ -24,9 +24,25 @@ func TestUnitSpecs(t *testing.T) {
 	r.AddSpec(DescribeMockMysqlConn)
 	r.AddSpec(DescribeMockStmt)
 
+	r.AddSpec(DescribeBlobStores)
+	r.AddSpec(DescribeSaveFile)
 	r.AddSpec(DescribeTransaction)
+	r.AddSpec(DescribeInTx)
+	r.AddSpec(DescribeSavepoints)
+	r.AddSpec(DescribeRetry)
+	r.AddSpec(DescribeQuery)
+	r.AddSpec(DescribeStmtCache)
+	r.AddSpec(DescribeFileHandler)
+	r.AddSpec(DescribeFileRefs)
+	r.AddSpec(DescribeFileRecords)
 
 	r.AddSpec(DescribeExecutorRegistry)
+	r.AddSpec(DescribeExecutorRegistryIntrospection)
+	r.AddSpec(DescribeChildExecutorRegistry)
+	r.AddSpec(DescribeDatabaseExecute)
+	r.AddSpec(DescribeMiddleware)
+	r.AddSpec(DescribeAuthorization)
+	r.AddSpec(DescribeHandler)
 
 	gospec.MainGoTest(r, t)
 }
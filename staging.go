@@ -0,0 +1,184 @@
+package database
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Files saved during a transaction are staged in the pending area of the
+// BlobStore and only promoted to their final name once the transaction
+// commits. Before the MySQL transaction is committed a journal listing the
+// staged files is written. If the process crashes after this point the
+// journal is replayed by RecoverFiles, promoting the files. This may
+// promote files for a transaction that failed to commit, but those are only
+// unreferenced copies of content and are never lost for one that did commit.
+const (
+	pendingBlobPrefix = ".pending/"
+	journalBlobPrefix = ".journal/"
+)
+
+// Blobs used by the store to implement transactions
+func isInternalBlob(name string) bool {
+	return strings.HasPrefix(name, pendingBlobPrefix) || strings.HasPrefix(name, journalBlobPrefix)
+}
+
+func pendingPrefix(txId string) string { return pendingBlobPrefix + txId + "/" }
+func journalName(txId string) string   { return journalBlobPrefix + txId }
+
+// A view of the BlobStore with every name prefixed
+type prefixedBlobStore struct {
+	BlobStore
+	prefix string
+}
+
+func (s prefixedBlobStore) Put(name string, r io.Reader) error {
+	return s.BlobStore.Put(s.prefix+name, r)
+}
+func (s prefixedBlobStore) Get(name string) (Blob, error) { return s.BlobStore.Get(s.prefix + name) }
+func (s prefixedBlobStore) Delete(name string) error      { return s.BlobStore.Delete(s.prefix + name) }
+
+func (s prefixedBlobStore) Rename(from, to string) error {
+	return s.BlobStore.Rename(s.prefix+from, s.prefix+to)
+}
+
+func (s prefixedBlobStore) Stat(name string) (BlobInfo, error) {
+	info, err := s.BlobStore.Stat(s.prefix + name)
+	info.Name = strings.TrimPrefix(info.Name, s.prefix)
+	return info, err
+}
+
+func (s prefixedBlobStore) List() ([]string, error) {
+	all, err := s.BlobStore.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range all {
+		if strings.HasPrefix(name, s.prefix) {
+			names = append(names, strings.TrimPrefix(name, s.prefix))
+		}
+	}
+	return names, nil
+}
+
+func writeJournal(store BlobStore, txId string, filenames []string) error {
+	return store.Put(journalName(txId), strings.NewReader(strings.Join(filenames, "\n")))
+}
+
+func readJournal(store BlobStore, txId string) ([]string, error) {
+	blob, err := store.Get(journalName(txId))
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	contents, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contents) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(contents), "\n"), nil
+}
+
+// Moves the staged files to their final names. A file that is no longer
+// staged has already been promoted by an earlier attempt if it exists.
+func promoteFiles(store BlobStore, txId string, filenames []string) error {
+	prefix := pendingPrefix(txId)
+	for _, filename := range filenames {
+		err := store.Rename(prefix+filename, filename)
+		if os.IsNotExist(err) {
+			if _, statErr := store.Stat(filename); statErr == nil {
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deletes the staged files. Files are content addressed, so a file with
+// the same name may already be referenced by another transaction,
+// but this only ever deletes the staged copies this transaction created.
+func discardFiles(store BlobStore, txId string, filenames []string) error {
+	prefix := pendingPrefix(txId)
+	for _, filename := range filenames {
+		err := store.Delete(prefix + filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes the temporary files left behind by writes that were interrupted,
+// completes the promotion of any transaction that has a journal and discards
+// the files staged by transactions that never committed. This must not run
+// while another process is using the BlobStore, the files staged by its open
+// transactions would be discarded.
+func RecoverFiles(store BlobStore) error {
+	if s, ok := store.(interface {
+		Sweep() error
+	}); ok {
+		if err := s.Sweep(); err != nil {
+			return err
+		}
+	}
+	return recoverFiles(store)
+}
+
+// Recover the BlobStore with RecoverFiles when the Database is created.
+// This is only safe when no other process shares the BlobStore.
+func WithFileRecovery() Option {
+	return func(db *Database) error {
+		db.recoverFiles = true
+		return nil
+	}
+}
+
+func recoverFiles(store BlobStore) error {
+	names, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, journalBlobPrefix) {
+			continue
+		}
+
+		txId := strings.TrimPrefix(name, journalBlobPrefix)
+		filenames, err := readJournal(store, txId)
+		if err != nil {
+			return err
+		}
+
+		if err := promoteFiles(store, txId, filenames); err != nil {
+			return err
+		}
+		if err := store.Delete(name); err != nil {
+			return err
+		}
+	}
+
+	// The files that were promoted have been moved and the rest were abandoned
+	for _, name := range names {
+		if !strings.HasPrefix(name, pendingBlobPrefix) {
+			continue
+		}
+
+		err := store.Delete(name)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"container/list"
+	"github.com/ziutek/mymysql/mysql"
+	"sync"
+)
+
+// The number of statements a Database's StmtCache holds unless it's created WithStmtCacheSize
+const DefaultStmtCacheSize = 256
+
+type StmtCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+type cachedStmt struct {
+	sql  string
+	stmt mysql.Stmt
+
+	// The number of Acquires that haven't been released
+	refs int
+	// Set by Prepare, the statement is never evicted
+	pinned bool
+	// The element in the idle list while it's neither pinned nor acquired
+	idle *list.Element
+}
+
+// Prepares a statement once for each SQL string and returns it for every later
+// use of the same SQL. Statements kept by their user, like an Executor's, are
+// prepared with Prepare and are never evicted. Others are used with Acquire
+// or Run and once the cache holds more than its size the least recently used
+// of those that aren't in use is deleted. A statement mustn't be deleted by its user.
+//
+// The connection is used when a statement is prepared or evicted, so a statement
+// that isn't cached yet mustn't be prepared while the same goroutine has a
+// transaction open on the connection. A statement the server no longer has,
+// because the connection was re-established, is forgotten when it fails to run
+// and prepared again by the next use. Reset forgets every statement at once.
+// Conn.Reconnect prepares the statements again itself.
+type StmtCache struct {
+	conn MymysqlConn
+	// 0 is unbounded
+	size int
+
+	mu      sync.Mutex
+	stmts   map[string]*cachedStmt
+	idleLRU *list.List
+	stats   StmtCacheStats
+}
+
+func NewStmtCache(conn MymysqlConn, size int) *StmtCache {
+	return &StmtCache{
+		conn:    conn,
+		size:    size,
+		stmts:   make(map[string]*cachedStmt),
+		idleLRU: list.New(),
+	}
+}
+
+// Prepare statements with a cache that holds at most size statements, 0 is unbounded
+func WithStmtCacheSize(size int) Option {
+	return func(db *Database) error {
+		db.stmtCacheSize = size
+		return nil
+	}
+}
+
+// Returns the statement prepared for sql and pins it so it's never evicted
+func (c *StmtCache) Prepare(sql string) (mysql.Stmt, error) {
+	cached, err := c.get(sql, func(cached *cachedStmt) {
+		cached.pinned = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cached.stmt, nil
+}
+
+// Returns the statement prepared for sql, it isn't evicted until release is called
+func (c *StmtCache) Acquire(sql string) (stmt mysql.Stmt, release func(), err error) {
+	cached, err := c.get(sql, func(cached *cachedStmt) {
+		cached.refs++
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cached.stmt, func() { c.release(cached) }, nil
+}
+
+// Runs the statement prepared for sql. If the server no longer has
+// the statement it's prepared again and run once more.
+func (c *StmtCache) Run(sql string, params ...interface{}) (mysql.Result, error) {
+	res, err := c.run(sql, params...)
+	if isUnknownStmt(err) {
+		res, err = c.run(sql, params...)
+	}
+	return res, err
+}
+
+func (c *StmtCache) run(sql string, params ...interface{}) (mysql.Result, error) {
+	stmt, release, err := c.Acquire(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	res, err := stmt.Run(params...)
+	if isUnknownStmt(err) {
+		c.forget(stmt)
+	}
+	return res, err
+}
+
+// Finds or prepares the statement for sql and marks it in use with use.
+// The connection is only used while the cache isn't locked.
+func (c *StmtCache) get(sql string, use func(*cachedStmt)) (*cachedStmt, error) {
+	c.mu.Lock()
+	if cached, exists := c.stmts[sql]; exists {
+		c.stats.Hits++
+		c.use(cached, use)
+		c.mu.Unlock()
+		return cached, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	stmt, err := c.conn.Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	cached, exists := c.stmts[sql]
+	if exists {
+		// Another goroutine prepared it at the same time
+		c.use(cached, use)
+	} else {
+		cached = &cachedStmt{sql: sql, stmt: stmt}
+		c.stmts[sql] = cached
+		c.use(cached, use)
+	}
+	evicted := c.evict()
+	c.mu.Unlock()
+
+	if exists {
+		stmt.Delete()
+	}
+	for _, stmt := range evicted {
+		stmt.Delete()
+	}
+	return cached, nil
+}
+
+func (c *StmtCache) use(cached *cachedStmt, use func(*cachedStmt)) {
+	if cached.idle != nil {
+		c.idleLRU.Remove(cached.idle)
+		cached.idle = nil
+	}
+	use(cached)
+}
+
+func (c *StmtCache) release(cached *cachedStmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached.refs--
+	if cached.refs == 0 && !cached.pinned && c.stmts[cached.sql] == cached {
+		cached.idle = c.idleLRU.PushFront(cached)
+	}
+}
+
+// Removes the least recently used idle statements until the cache
+// fits its size and returns them to be deleted once it's unlocked
+func (c *StmtCache) evict() []mysql.Stmt {
+	var evicted []mysql.Stmt
+	for c.size > 0 && len(c.stmts) > c.size && c.idleLRU.Len() > 0 {
+		cached := c.idleLRU.Remove(c.idleLRU.Back()).(*cachedStmt)
+		cached.idle = nil
+		delete(c.stmts, cached.sql)
+
+		c.stats.Evictions++
+		evicted = append(evicted, cached.stmt)
+	}
+	return evicted
+}
+
+// Removes the statement from the cache without deleting it
+func (c *StmtCache) forget(stmt mysql.Stmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for sql, cached := range c.stmts {
+		if cached.stmt == stmt {
+			if cached.idle != nil {
+				c.idleLRU.Remove(cached.idle)
+				cached.idle = nil
+			}
+			delete(c.stmts, sql)
+			return
+		}
+	}
+}
+
+// Forgets every statement without deleting them. Call this once the
+// connection has been closed and connected again, the server discards
+// the statements prepared on a connection when it's closed.
+func (c *StmtCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stmts = make(map[string]*cachedStmt)
+	c.idleLRU.Init()
+}
+
+// Deletes every statement
+func (c *StmtCache) Close() error {
+	c.mu.Lock()
+	stmts := c.stmts
+	c.stmts = make(map[string]*cachedStmt)
+	c.idleLRU.Init()
+	c.mu.Unlock()
+
+	var err error
+	for _, cached := range stmts {
+		if deleteErr := cached.stmt.Delete(); err == nil {
+			err = deleteErr
+		}
+	}
+	return err
+}
+
+// The number of statements in the cache
+func (c *StmtCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.stmts)
+}
+
+func (c *StmtCache) Stats() StmtCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Reports if the server didn't recognize the statement that was executed
+func isUnknownStmt(err error) bool {
+	code, ok := mysqlErrorCode(err)
+	return ok && code == mysql.ER_UNKNOWN_STMT_HANDLER
+}
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"errors"
+	"github.com/ghthor/gospec"
+	. "github.com/ghthor/gospec"
+	"github.com/ziutek/mymysql/mysql"
+	"time"
+)
+
+// Reports if the cache could be used before the timeout,
+// it can't while it's locked
+func stmtCacheUnlocked(cache *StmtCache) bool {
+	done := make(chan struct{})
+	go func() {
+		cache.Len()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+func DescribeStmtCache(c gospec.Context) {
+	prepared := make(map[string]int)
+	conn := &MockMysqlConn{
+		PrepareFunc: func(sql string) (mysql.Stmt, error) {
+			prepared[sql]++
+			return &MockStmt{}, nil
+		},
+	}
+	cache := NewStmtCache(conn, 2)
+
+	// Acquires the statement and releases it straight away
+	use := func(sql string) mysql.Stmt {
+		stmt, release, err := cache.Acquire(sql)
+		c.Assume(err, IsNil)
+		release()
+		return stmt
+	}
+
+	c.Specify("a statement cache", func() {
+		c.Specify("prepares a statement once for each sql string", func() {
+			first, err := cache.Prepare("SELECT 1")
+			c.Assume(err, IsNil)
+			second := use("SELECT 1")
+
+			c.Expect(first == second, IsTrue)
+			c.Expect(prepared["SELECT 1"], Equals, 1)
+			c.Expect(cache.Stats(), Equals, StmtCacheStats{Hits: 1, Misses: 1})
+		})
+
+		c.Specify("doesn't keep a statement that fails to prepare", func() {
+			prepareErr := errors.New("syntax error")
+			conn.PrepareFunc = func(string) (mysql.Stmt, error) { return nil, prepareErr }
+
+			_, err := cache.Prepare("SELEC 1")
+			c.Expect(err, Equals, prepareErr)
+			c.Expect(cache.Len(), Equals, 0)
+		})
+
+		c.Specify("deletes the least recently used statement when it's full", func() {
+			stmt1 := use("SELECT 1")
+			stmt2 := use("SELECT 2")
+			use("SELECT 1")
+			use("SELECT 3")
+
+			c.Expect(cache.Len(), Equals, 2)
+			c.Expect(stmt2.(*MockStmt).DeleteWasCalled, IsTrue)
+			c.Expect(stmt1.(*MockStmt).DeleteWasCalled, IsFalse)
+			c.Expect(cache.Stats().Evictions, Equals, uint64(1))
+
+			use("SELECT 2")
+			c.Expect(prepared["SELECT 2"], Equals, 2)
+		})
+
+		c.Specify("doesn't delete a statement that is kept", func() {
+			kept, err := cache.Prepare("SELECT 1")
+			c.Assume(err, IsNil)
+			use("SELECT 2")
+			use("SELECT 3")
+			use("SELECT 4")
+
+			c.Expect(kept.(*MockStmt).DeleteWasCalled, IsFalse)
+			c.Expect(cache.Len(), Equals, 2)
+		})
+
+		c.Specify("doesn't delete a statement until it's released", func() {
+			acquired, release, err := cache.Acquire("SELECT 1")
+			c.Assume(err, IsNil)
+			use("SELECT 2")
+			use("SELECT 3")
+
+			c.Expect(acquired.(*MockStmt).DeleteWasCalled, IsFalse)
+
+			release()
+			use("SELECT 4")
+			use("SELECT 5")
+			c.Expect(acquired.(*MockStmt).DeleteWasCalled, IsTrue)
+		})
+
+		c.Specify("isn't locked while it prepares or deletes a statement", func() {
+			var unlocked []bool
+			conn.PrepareFunc = func(string) (mysql.Stmt, error) {
+				unlocked = append(unlocked, stmtCacheUnlocked(cache))
+				return &MockStmt{DeleteFunc: func() error {
+					unlocked = append(unlocked, stmtCacheUnlocked(cache))
+					return nil
+				}}, nil
+			}
+
+			use("SELECT 1")
+			use("SELECT 2")
+			use("SELECT 3")
+
+			c.Expect(len(unlocked), Equals, 4)
+			for _, u := range unlocked {
+				c.Expect(u, IsTrue)
+			}
+		})
+
+		c.Specify("prepares every statement again once it's reset", func() {
+			stmt := use("SELECT 1")
+			cache.Reset()
+
+			c.Expect(cache.Len(), Equals, 0)
+			c.Expect(stmt.(*MockStmt).DeleteWasCalled, IsFalse)
+
+			use("SELECT 1")
+			c.Expect(prepared["SELECT 1"], Equals, 2)
+		})
+
+		c.Specify("deletes every statement when it's closed", func() {
+			stmt1, _ := cache.Prepare("SELECT 1")
+			stmt2 := use("SELECT 2")
+
+			c.Expect(cache.Close(), IsNil)
+			c.Expect(cache.Len(), Equals, 0)
+			c.Expect(stmt1.(*MockStmt).DeleteWasCalled, IsTrue)
+			c.Expect(stmt2.(*MockStmt).DeleteWasCalled, IsTrue)
+		})
+
+		c.Specify("runs a statement the server no longer has after preparing it again", func() {
+			stale := &MockStmt{RunFunc: func(...interface{}) (mysql.Result, error) {
+				return nil, &mysql.Error{Code: mysql.ER_UNKNOWN_STMT_HANDLER}
+			}}
+			fresh := &MockStmt{}
+
+			stmts := []mysql.Stmt{stale, fresh}
+			conn.PrepareFunc = func(string) (mysql.Stmt, error) {
+				stmt := stmts[0]
+				stmts = stmts[1:]
+				return stmt, nil
+			}
+
+			_, err := cache.Run("SELECT 1", 1)
+			c.Expect(err, IsNil)
+			c.Expect(fresh.RunWasCalled, IsTrue)
+			c.Expect(stale.DeleteWasCalled, IsFalse)
+
+			c.Expect(use("SELECT 1") == mysql.Stmt(fresh), IsTrue)
+		})
+
+		c.Specify("is forgotten by a transaction when the server no longer has it", func() {
+			stmt, _ := cache.Prepare("SELECT 1")
+			unknownErr := &mysql.Error{Code: mysql.ER_UNKNOWN_STMT_HANDLER}
+			stmt.(*MockStmt).RunFunc = func(...interface{}) (mysql.Result, error) {
+				return nil, unknownErr
+			}
+
+			tx := newTransaction(&MockMysqlTx{}, NewMemBlobStore())
+			tx.stmts = cache
+
+			_, err := tx.Run(stmt)
+			c.Expect(err, Equals, error(unknownErr))
+			c.Expect(cache.Len(), Equals, 0)
+		})
+	})
+
+	c.Specify("a database keeps the statements it prepares in a cache of the configured size", func() {
+		db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(),
+			WithExecutorRegistry(NewExecutorRegistry()),
+			withMysqlConn(conn),
+			WithStmtCacheSize(1),
+		)
+		c.Assume(err, IsNil)
+
+		kept, err := db.Prepare("SELECT 1")
+		c.Assume(err, IsNil)
+		db.Prepare("SELECT 1")
+		stmt, release, err := db.StmtCache().Acquire("SELECT 2")
+		c.Assume(err, IsNil)
+		release()
+		db.StmtCache().Acquire("SELECT 3")
+
+		c.Expect(prepared["SELECT 1"], Equals, 1)
+		c.Expect(kept.(*MockStmt).DeleteWasCalled, IsFalse)
+		c.Expect(stmt.(*MockStmt).DeleteWasCalled, IsTrue)
+		c.Expect(db.StmtCache().Stats(), Equals, StmtCacheStats{Hits: 1, Misses: 3, Evictions: 1})
+	})
+}
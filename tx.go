@@ -1,11 +1,11 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"github.com/ghthor/database/datatype"
 	"github.com/ziutek/mymysql/mysql"
-	"os"
-	"path"
+	"sync"
 )
 
 type RollbackError struct {
@@ -17,72 +17,434 @@ func (e RollbackError) Error() string {
 	return fmt.Sprintf("%v after %v", e.err, e.triggeredBy)
 }
 
+// The error that caused the rollback
+func (e RollbackError) Unwrap() error { return e.triggeredBy }
+
+// Returned by Commit when MySQL has committed but the saved files couldn't be
+// promoted. They stay staged with the journal until RecoverFiles promotes them.
+type PromoteError struct {
+	Err error
+}
+
+func (e PromoteError) Error() string {
+	return fmt.Sprintf("committed but the saved files weren't promoted: %v", e.Err)
+}
+
+func (e PromoteError) Unwrap() error { return e.Err }
+
+// Once a Transaction has been committed or rolled back,
+// including by a failed Run, its methods return ErrTxDone.
 type Transaction interface {
 	Commit() error
 	Rollback() error
+	// The result must be read completely before the next statement is run
 	Run(mysql.Stmt, ...interface{}) (mysql.Result, error)
-	//SaveFiles([]datatype.FormFile) ([]string, error)
-	SaveFile(datatype.FormFile) (string, error)
+	RunContext(context.Context, mysql.Stmt, ...interface{}) (mysql.Result, error)
+	// These read the result completely, see ScanStruct to read the rows
+	Query(mysql.Stmt, ...interface{}) ([]mysql.Row, mysql.Result, error)
+	QueryFirst(mysql.Stmt, ...interface{}) (mysql.Row, mysql.Result, error)
+	Exec(mysql.Stmt, ...interface{}) (mysql.Result, error)
+	SaveFile(datatype.FormFile) (datatype.StoredFile, error)
+	SaveFileContext(context.Context, datatype.FormFile) (datatype.StoredFile, error)
+	SaveFiles([]datatype.FormFile) ([]datatype.StoredFile, error)
+	SaveFilesContext(context.Context, []datatype.FormFile) ([]datatype.StoredFile, error)
+	// Removes a reference to a saved file when the transaction commits,
+	// this does nothing unless the Database was created WithFileRefs
+	ReleaseFile(string) error
+
+	// Rolling back to a savepoint also removes the files saved after it
+	Savepoint(string) error
+	RollbackTo(string) error
+	Release(string) error
+	// Begins a nested transaction within this one using a savepoint
+	Begin() (Transaction, error)
 }
 
 type mysqlTransaction interface {
 	Commit() error
 	Rollback() error
 	Do(mysql.Stmt) mysql.Stmt
+	Start(string, ...interface{}) (mysql.Result, error)
 }
 
 type transaction struct {
 	tx mysqlTransaction
 
-	filepath   string
+	blobStore BlobStore
+	fileHash  FileHash
+	// Replaced by the policy of the action being executed, see FilePolicyAction
+	filePolicy FilePolicy
+	// Identifies the files staged by this transaction, generated by the first SaveFile
+	id         string
 	savedFiles []string
+	savepoints []savepoint
+	// The number of nested transactions begun, used to name their savepoints
+	nested int
+
+	refs *fileRefs
+	// Releases the read lock on refs taken by Database.Begin
+	unlockRefs func()
+	records    *fileRecords
+	// Forgets a statement the server no longer has so it's prepared again
+	stmts *StmtCache
+
+	// Set once the transaction has been committed or rolled back
+	done bool
+	// Set if it was committed rather than rolled back
+	committed bool
+	// The error that rolled it back, see abort
+	abortErr error
 }
 
-func newTransaction(tx mysqlTransaction, filepath string) *transaction {
-	return &transaction{tx, filepath, make([]string, 0, 1)}
+func newTransaction(tx mysqlTransaction, blobStore BlobStore) *transaction {
+	return &transaction{
+		tx:         tx,
+		blobStore:  blobStore,
+		fileHash:   SHA1,
+		savedFiles: make([]string, 0, 1),
+	}
 }
 
-func (t *transaction) Commit() error { return t.tx.Commit() }
-func (t *transaction) Rollback() error {
-	for _, filename := range t.savedFiles {
-		err := os.Remove(path.Join(t.filepath, filename))
-		if err != nil {
-			return err
+// The saved files are promoted from the pending area once MySQL has committed.
+// If the commit fails they're discarded.
+func (t *transaction) Commit() error {
+	if t.done {
+		return ErrTxDone
+	}
+	// Files mustn't be garbage collected before their references are visible
+	committed := false
+	defer func() { t.finish(committed) }()
+
+	if len(t.savedFiles) == 0 {
+		err := t.tx.Commit()
+		committed = err == nil
+		return err
+	}
+
+	err := writeJournal(t.blobStore, t.id, t.savedFiles)
+	if err != nil {
+		return t.abort(err)
+	}
+
+	err = t.tx.Commit()
+	if err != nil {
+		discardErr := discardFiles(t.blobStore, t.id, t.savedFiles)
+		if discardErr == nil {
+			discardErr = t.blobStore.Delete(journalName(t.id))
+		}
+
+		if discardErr != nil {
+			return RollbackError{discardErr, err}
 		}
+		return err
+	}
+	committed = true
+
+	// The journal is left behind if this fails so RecoverFiles can finish promoting
+	err = promoteFiles(t.blobStore, t.id, t.savedFiles)
+	if err != nil {
+		return PromoteError{err}
+	}
+
+	// A journal that's left behind is replayed harmlessly by RecoverFiles
+	t.blobStore.Delete(journalName(t.id))
+	return nil
+}
+
+func (t *transaction) Rollback() error {
+	if t.done {
+		return ErrTxDone
+	}
+	defer t.finish(false)
+
+	return t.rollback()
+}
+
+func (t *transaction) rollback() error {
+	err := discardFiles(t.blobStore, t.id, t.savedFiles)
+	if err != nil {
+		return err
 	}
 	return t.tx.Rollback()
 }
 
+// Marks the transaction as done once it has been committed or rolled back
+func (t *transaction) finish(committed bool) {
+	t.done = true
+	t.committed = committed
+	if t.unlockRefs != nil {
+		t.unlockRefs()
+		t.unlockRefs = nil
+	}
+}
+
+// Rollback because of err
+func (t *transaction) abort(err error) error {
+	t.abortErr = err
+	defer t.finish(false)
+
+	rollbackErr := t.rollback()
+	if rollbackErr != nil {
+		return RollbackError{rollbackErr, err}
+	}
+	return err
+}
+
 func (t *transaction) Run(s mysql.Stmt, params ...interface{}) (mysql.Result, error) {
+	return t.RunContext(context.Background(), s, params...)
+}
+
+// The statement isn't interrupted if the context is cancelled while it's running,
+// but the transaction will be rolled back once it completes. The rows of its
+// result are discarded first, the connection is locked until they're read.
+func (t *transaction) RunContext(ctx context.Context, s mysql.Stmt, params ...interface{}) (mysql.Result, error) {
+	if t.done {
+		return nil, ErrTxDone
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, t.abort(err)
+	}
+
 	// TODO: Specify params... with an Integration Test
 	res, err := t.tx.Do(s).Run(params...)
 	if err != nil {
-		rollbackErr := t.Rollback()
-		if rollbackErr != nil {
-			return nil, RollbackError{rollbackErr, err}
-		} else {
-			return nil, err
+		if t.stmts != nil && isUnknownStmt(err) {
+			t.stmts.forget(s)
+		}
+		return nil, t.abort(err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		if discardErr := discardResult(res); discardErr != nil {
+			err = discardErr
 		}
+		return nil, t.abort(err)
 	}
 	return res, nil
 }
 
-func (t *transaction) SaveFile(formFile datatype.FormFile) (string, error) {
-	return t.saveFile(formFile, saveFile)
+func (t *transaction) SaveFile(formFile datatype.FormFile) (datatype.StoredFile, error) {
+	return t.SaveFileContext(context.Background(), formFile)
 }
 
-func (t *transaction) saveFile(formFile datatype.FormFile, savefn func(datatype.FormFile, string) (string, error)) (string, error) {
-	filename, err := savefn(formFile, t.filepath)
+// Copying the file is aborted if the context is cancelled.
+// A file that isn't allowed by the FilePolicy is rejected with
+// an *action.FieldError wrapping action.ErrInvalidFilename.
+func (t *transaction) SaveFileContext(ctx context.Context, formFile datatype.FormFile) (datatype.StoredFile, error) {
+	policy := t.policyFor(ctx)
+
+	formFile.File = contextFile{ctx, formFile.File}
+	return t.saveFile(formFile, func(formFile datatype.FormFile, store BlobStore) (datatype.StoredFile, error) {
+		return saveFile(formFile, store, t.fileHash, policy)
+	})
+}
+
+func (t *transaction) SaveFiles(formFiles []datatype.FormFile) ([]datatype.StoredFile, error) {
+	return t.SaveFilesContext(context.Background(), formFiles)
+}
+
+// The number of files SaveFiles writes at the same time
+const saveFilesWorkers = 4
+
+// The files are written concurrently and are returned in the same order.
+// If any of them fails the rest are abandoned and the transaction is rolled back,
+// removing every file it has saved.
+func (t *transaction) SaveFilesContext(ctx context.Context, formFiles []datatype.FormFile) ([]datatype.StoredFile, error) {
+	if t.done {
+		return nil, ErrTxDone
+	}
+
+	policy := t.policyFor(ctx)
+
+	pending, err := t.pending()
 	if err != nil {
-		rollbackErr := t.Rollback()
-		if rollbackErr != nil {
-			return "", RollbackError{rollbackErr, err}
-		} else {
-			return "", err
+		return nil, t.abort(err)
+	}
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		stored   = make([]datatype.StoredFile, len(formFiles))
+		firstErr error
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+	)
+
+	// The first failure cancels the files that are still being written
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	indexes := make(chan int)
+	for w := 0; w < saveFilesWorkers && w < len(formFiles); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				formFile := formFiles[i]
+				formFile.File = contextFile{workerCtx, formFile.File}
+
+				file, err := saveFile(formFile, pending, t.fileHash, policy)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				stored[i] = file
+			}
+		}()
+	}
+
+dispatch:
+	for i := range formFiles {
+		select {
+		case indexes <- i:
+		case <-workerCtx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, file := range stored {
+		if file.Name != "" {
+			t.recordSavedFile(file.Name)
+		}
+	}
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, t.abort(firstErr)
+	}
+
+	for i := range stored {
+		if err := t.addFileRef(stored[i].Name); err != nil {
+			return nil, err
+		}
+		if err := t.recordStoredFile(&stored[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return stored, nil
+}
+
+// The policy of the action being executed replaces the transaction's
+func (t *transaction) policyFor(ctx context.Context) FilePolicy {
+	if p, ok := filePolicyFromContext(ctx); ok {
+		return p
+	}
+	return t.filePolicy
+}
+
+func (t *transaction) ReleaseFile(filename string) error {
+	if t.done {
+		return ErrTxDone
+	}
+
+	if t.refs == nil {
+		return nil
+	}
+
+	_, err := t.tx.Do(t.refs.release).Run(filename)
+	if err != nil {
+		return t.abort(err)
+	}
+	return nil
+}
+
+// Staging area for the files saved by this transaction
+func (t *transaction) pending() (BlobStore, error) {
+	if t.id == "" {
+		id, err := genSuffix()
+		if err != nil {
+			return nil, err
+		}
+		t.id = id
+	}
+	return prefixedBlobStore{t.blobStore, pendingPrefix(t.id)}, nil
+}
+
+func (t *transaction) saveFile(formFile datatype.FormFile, savefn func(datatype.FormFile, BlobStore) (datatype.StoredFile, error)) (datatype.StoredFile, error) {
+	if t.done {
+		return datatype.StoredFile{}, ErrTxDone
+	}
+
+	pending, err := t.pending()
+	if err != nil {
+		return datatype.StoredFile{}, t.abort(err)
+	}
+
+	stored, err := savefn(formFile, pending)
+	if err != nil {
+		return datatype.StoredFile{}, t.abort(err)
+	}
+
+	t.recordSavedFile(stored.Name)
+
+	if err := t.addFileRef(stored.Name); err != nil {
+		return datatype.StoredFile{}, err
+	}
+	if err := t.recordStoredFile(&stored); err != nil {
+		return datatype.StoredFile{}, err
+	}
+
+	return stored, nil
+}
+
+func (t *transaction) addFileRef(filename string) error {
+	if t.refs == nil {
+		return nil
+	}
+
+	if _, err := t.tx.Do(t.refs.add).Run(filename); err != nil {
+		return t.abort(err)
+	}
+	return nil
+}
+
+func (t *transaction) recordStoredFile(stored *datatype.StoredFile) error {
+	if t.records == nil {
+		return nil
+	}
+
+	res, err := t.tx.Do(t.records.insert).Run(stored.Name, stored.Filename, stored.Size, stored.MimeType, stored.UploadedAt)
+	if err != nil {
+		return t.abort(err)
+	}
+
+	stored.Id = datatype.Id(res.InsertId())
+	return nil
+}
+
+// A file saved twice is only staged once
+func (t *transaction) recordSavedFile(filename string) {
+	for _, saved := range t.savedFiles {
+		if saved == filename {
+			return
 		}
 	}
 
 	t.savedFiles = append(t.savedFiles, filename)
+}
+
+// Fails every Read once the context is done
+type contextFile struct {
+	ctx context.Context
+	datatype.UploadedTempFile
+}
 
-	return filename, nil
+func (f contextFile) Read(p []byte) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.UploadedTempFile.Read(p)
 }
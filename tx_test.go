@@ -2,38 +2,62 @@ package database
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"github.com/ghthor/database/action"
 	"github.com/ghthor/database/datatype"
 	"github.com/ghthor/gospec"
 	. "github.com/ghthor/gospec"
 	"github.com/ziutek/mymysql/mysql"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"os"
-	"path"
 	"path/filepath"
+	"strings"
 )
 
 type MockMysqlTx struct {
 	CommitWasCalled   bool
+	CommitFunc        func() error
 	RollbackWasCalled bool
+	RollbackFunc      func() error
 
 	DoWasCalled bool
 	DoFunc      func(mysql.Stmt) mysql.Stmt
+
+	// The sql of every statement that was started
+	Started   []string
+	StartFunc func(string) (mysql.Result, error)
 }
 
 func (t *MockMysqlTx) Commit() error {
 	t.CommitWasCalled = true
+	if t.CommitFunc != nil {
+		return t.CommitFunc()
+	}
 	return nil
 }
 
 func (t *MockMysqlTx) Rollback() error {
 	t.RollbackWasCalled = true
+	if t.RollbackFunc != nil {
+		return t.RollbackFunc()
+	}
 	return nil
 }
 
+func (t *MockMysqlTx) Start(sql string, params ...interface{}) (mysql.Result, error) {
+	t.Started = append(t.Started, sql)
+	if t.StartFunc != nil {
+		return t.StartFunc(sql)
+	}
+	return &MockResult{}, nil
+}
+
 func (t *MockMysqlTx) Do(s mysql.Stmt) mysql.Stmt {
 	t.DoWasCalled = true
 	if t.DoFunc != nil {
@@ -43,13 +67,8 @@ func (t *MockMysqlTx) Do(s mysql.Stmt) mysql.Stmt {
 }
 
 func DescribeTransaction(c gospec.Context) {
-	// TODO: Extract filepath stuff into a type that can me mocked so no filesystem calls are made during this test
-	tmp, err := ioutil.TempDir("", "transaction-spec")
-	c.Assume(err, IsNil)
-
-	defer func() { c.Assume(os.RemoveAll(tmp), IsNil) }()
-
-	tx := newTransaction(&MockMysqlTx{}, tmp)
+	store := NewMemBlobStore()
+	tx := newTransaction(&MockMysqlTx{}, store)
 
 	type TestFile struct {
 		file     datatype.FormFile
@@ -80,9 +99,108 @@ func DescribeTransaction(c gospec.Context) {
 	}
 
 	c.Specify("a transaction", func() {
-		filename, err := tx.SaveFile(files["png"].file)
+		stored, err := tx.SaveFile(files["png"].file)
 		c.Assume(err, IsNil)
-		c.Assume(filename, Equals, files["png"].sha1name)
+		c.Assume(stored.Name, Equals, files["png"].sha1name)
+
+		c.Specify("stages saved files until it commits", func() {
+			_, err := store.Stat(files["png"].sha1name)
+			c.Expect(os.IsNotExist(err), IsTrue)
+
+			_, err = store.Stat(pendingPrefix(tx.id) + files["png"].sha1name)
+			c.Expect(err, IsNil)
+		})
+
+		c.Specify("promotes saved files when it commits", func() {
+			c.Assume(tx.Commit(), IsNil)
+
+			names, err := store.List()
+			c.Assume(err, IsNil)
+			c.Expect(strings.Join(names, ","), Equals, files["png"].sha1name)
+
+			blob, err := store.Get(files["png"].sha1name)
+			c.Assume(err, IsNil)
+			contents, err := ioutil.ReadAll(blob)
+			c.Assume(err, IsNil)
+			c.Expect(bytes.Equal(contents, files["png"].bytes), IsTrue)
+		})
+
+		c.Specify("journals the saved files before mysql commits", func() {
+			var journal []string
+			tx.tx.(*MockMysqlTx).CommitFunc = func() error {
+				journal, err = readJournal(store, tx.id)
+				return err
+			}
+
+			c.Assume(tx.Commit(), IsNil)
+			c.Expect(strings.Join(journal, ","), Equals, files["png"].sha1name)
+
+			_, err := store.Stat(journalName(tx.id))
+			c.Expect(os.IsNotExist(err), IsTrue)
+		})
+
+		c.Specify("discards saved files if mysql fails to commit", func() {
+			tx.tx.(*MockMysqlTx).CommitFunc = func() error { return errors.New("commit failed") }
+
+			err := tx.Commit()
+			c.Expect(err.Error(), Equals, "commit failed")
+
+			names, err := store.List()
+			c.Assume(err, IsNil)
+			c.Expect(len(names), Equals, 0)
+		})
+
+		c.Specify("saves a file once if it's saved twice", func() {
+			png, err := testFile(filenames["png"])
+			c.Assume(err, IsNil)
+
+			_, err = tx.SaveFile(png)
+			c.Assume(err, IsNil)
+			c.Expect(len(tx.savedFiles), Equals, 1)
+			c.Expect(tx.Commit(), IsNil)
+		})
+
+		c.Specify("that crashed after being journaled", func() {
+			c.Assume(writeJournal(store, tx.id, tx.savedFiles), IsNil)
+
+			other := newTransaction(&MockMysqlTx{}, store)
+			_, err := other.SaveFile(files["txt"].file)
+			c.Assume(err, IsNil)
+
+			c.Specify("is promoted by the next database created with file recovery", func() {
+				_, err := NewDatabase(&MysqlDatabase{}, store, WithExecutorRegistry(NewExecutorRegistry()), WithFileRecovery())
+				c.Assume(err, IsNil)
+
+				names, err := store.List()
+				c.Assume(err, IsNil)
+				c.Expect(strings.Join(names, ","), Equals, files["png"].sha1name)
+			})
+		})
+
+		c.Specify("keeps its staged files when another database is created", func() {
+			_, err := NewDatabase(&MysqlDatabase{}, store, WithExecutorRegistry(NewExecutorRegistry()))
+			c.Assume(err, IsNil)
+
+			c.Assume(tx.Commit(), IsNil)
+			_, err = store.Stat(files["png"].sha1name)
+			c.Expect(err, IsNil)
+		})
+
+		c.Specify("fails to commit if a staged file is missing", func() {
+			c.Assume(store.Delete(pendingPrefix(tx.id)+files["png"].sha1name), IsNil)
+
+			var perr PromoteError
+			c.Expect(errors.As(tx.Commit(), &perr), IsTrue)
+			c.Expect(tx.tx.(*MockMysqlTx).CommitWasCalled, IsTrue)
+		})
+
+		c.Specify("commits if its journal can't be deleted", func() {
+			tx.blobStore = undeletableBlobStore{store}
+
+			c.Expect(tx.Commit(), IsNil)
+			_, err := store.Stat(files["png"].sha1name)
+			c.Expect(err, IsNil)
+		})
 
 		// TODO: Specify RollbackError behavior
 		c.Specify("will rollback", func() {
@@ -100,28 +218,316 @@ func DescribeTransaction(c gospec.Context) {
 				c.Expect(tx.tx.(*MockMysqlTx).RollbackWasCalled, IsTrue)
 
 				c.Specify("and will remove any successfully saved files", func() {
-					_, err := os.Stat(path.Join(tmp, files["png"].sha1name))
+					_, err := store.Stat(files["png"].sha1name)
 					c.Expect(os.IsNotExist(err), IsTrue)
 				})
 			})
 
 			c.Specify("during a failed save file action", func() {
-				_, err := tx.saveFile(files["txt"].file, func(datatype.FormFile, string) (string, error) {
-					return "", errors.New("error saving file")
+				_, err := tx.saveFile(files["txt"].file, func(datatype.FormFile, BlobStore) (datatype.StoredFile, error) {
+					return datatype.StoredFile{}, errors.New("error saving file")
 				})
 				c.Assume(err, Not(IsNil))
 				c.Assume(err.Error(), Equals, "error saving file")
 
 				c.Expect(tx.tx.(*MockMysqlTx).RollbackWasCalled, IsTrue)
 
-				_, err = os.Stat(path.Join(tmp, files["txt"].sha1name))
+				_, err = store.Stat(files["txt"].sha1name)
+				c.Expect(os.IsNotExist(err), IsTrue)
+
+				c.Specify("and will remove any successfully saved files", func() {
+					_, err := store.Stat(files["png"].sha1name)
+					c.Expect(os.IsNotExist(err), IsTrue)
+				})
+			})
+
+			c.Specify("when running a statement with a cancelled context", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				stmt := &MockStmt{}
+				_, err := tx.RunContext(ctx, stmt)
+				c.Expect(err, Equals, context.Canceled)
+				c.Expect(stmt.RunWasCalled, IsFalse)
+				c.Expect(tx.tx.(*MockMysqlTx).RollbackWasCalled, IsTrue)
+			})
+
+			c.Specify("when the context is cancelled while a statement is running", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				stmt := &MockStmt{
+					RunFunc: func(...interface{}) (mysql.Result, error) {
+						cancel()
+						return &MockResult{}, nil
+					},
+				}
+
+				_, err := tx.RunContext(ctx, stmt)
+				c.Expect(err, Equals, context.Canceled)
+				c.Expect(tx.tx.(*MockMysqlTx).RollbackWasCalled, IsTrue)
+
+				c.Specify("after reading the rows of the result", func() {
+					more := &MockResult{}
+					res := &MockResult{
+						Columns: []string{"id"},
+						Rows:    []mysql.Row{{1}, {2}},
+						More:    []*MockResult{more},
+					}
+
+					ctx, cancel := context.WithCancel(context.Background())
+					stmt.RunFunc = func(...interface{}) (mysql.Result, error) {
+						cancel()
+						return res, nil
+					}
+
+					// A rollback blocks on the connection until the rows are read
+					mysqlTx := &MockMysqlTx{RollbackFunc: func() error {
+						if !res.EndWasCalled || !more.EndWasCalled {
+							return errors.New("rolled back with unread rows")
+						}
+						return nil
+					}}
+					tx := newTransaction(mysqlTx, store)
+
+					_, err := tx.RunContext(ctx, stmt)
+					c.Expect(err, Equals, context.Canceled)
+					c.Expect(mysqlTx.RollbackWasCalled, IsTrue)
+				})
+			})
+
+			c.Specify("when the context is cancelled while saving a file", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				txtFile := files["txt"].file
+				txtFile.File = &cancellingFile{txtFile.File, cancel}
+
+				_, err := tx.SaveFileContext(ctx, txtFile)
+				c.Expect(err, Equals, context.Canceled)
+				c.Expect(tx.tx.(*MockMysqlTx).RollbackWasCalled, IsTrue)
+
+				_, err = store.Stat(files["txt"].sha1name)
 				c.Expect(os.IsNotExist(err), IsTrue)
 
 				c.Specify("and will remove any successfully saved files", func() {
-					_, err := os.Stat(path.Join(tmp, files["png"].sha1name))
+					_, err := store.Stat(files["png"].sha1name)
 					c.Expect(os.IsNotExist(err), IsTrue)
 				})
 			})
+
+			c.Specify("when a file isn't allowed by the policy on the context", func() {
+				ctx := withFilePolicy(context.Background(), FilePolicy{AllowedTypes: []string{"image/png"}})
+
+				_, err := tx.SaveFileContext(ctx, files["txt"].file)
+				c.Expect(errors.Is(err, action.ErrInvalidFilename), IsTrue)
+				c.Expect(tx.tx.(*MockMysqlTx).RollbackWasCalled, IsTrue)
+			})
+		})
+	})
+
+	c.Specify("a transaction saving several files", func() {
+		var formFiles []datatype.FormFile
+		var expected []string
+		for i := 0; i < 2*saveFilesWorkers+1; i++ {
+			contents := fmt.Sprintf("file %d", i)
+			formFiles = append(formFiles, datatype.FormFile{
+				File:   strings.NewReader(contents),
+				Header: &multipart.FileHeader{Filename: "file.txt"},
+			})
+
+			sum := sha1.Sum([]byte(contents))
+			expected = append(expected, hex.EncodeToString(sum[:])+".txt")
+		}
+
+		c.Specify("returns them in order", func() {
+			stored, err := tx.SaveFiles(formFiles)
+			c.Assume(err, IsNil)
+
+			var filenames []string
+			for _, file := range stored {
+				filenames = append(filenames, file.Name)
+			}
+			c.Expect(strings.Join(filenames, ","), Equals, strings.Join(expected, ","))
+
+			c.Assume(tx.Commit(), IsNil)
+
+			names, err := store.List()
+			c.Assume(err, IsNil)
+			c.Expect(len(names), Equals, len(expected))
+		})
+
+		c.Specify("rolls back and removes every file if one fails", func() {
+			formFiles[saveFilesWorkers].File = io.MultiReader(strings.NewReader("partial"), failingReader{})
+
+			_, err := tx.SaveFiles(formFiles)
+			c.Expect(err, Not(IsNil))
+			c.Expect(tx.tx.(*MockMysqlTx).RollbackWasCalled, IsTrue)
+
+			names, err := store.List()
+			c.Assume(err, IsNil)
+			c.Expect(len(names), Equals, 0)
+		})
+	})
+}
+
+func DescribeInTx(c gospec.Context) {
+	conn, _ := newStmtsConn()
+
+	mysqlTx := &MockMysqlTx{}
+	conn.BeginFunc = func() (mysql.Transaction, error) {
+		return mockTransaction{mysqlTx}, nil
+	}
+
+	db, err := NewDatabase(&MysqlDatabase{}, NewMemBlobStore(),
+		WithExecutorRegistry(NewExecutorRegistry()),
+		withMysqlConn(conn),
+	)
+	c.Assume(err, IsNil)
+
+	c.Specify("a closure run in a transaction", func() {
+		c.Specify("is committed if it succeeds", func() {
+			res, err := db.InTx(func(Transaction) (interface{}, error) {
+				return "result", nil
+			})
+			c.Assume(err, IsNil)
+			c.Expect(res, Equals, "result")
+			c.Expect(mysqlTx.CommitWasCalled, IsTrue)
+			c.Expect(mysqlTx.RollbackWasCalled, IsFalse)
+		})
+
+		c.Specify("is rolled back if it fails", func() {
+			_, err := db.InTx(func(Transaction) (interface{}, error) {
+				return nil, errors.New("failed")
+			})
+			c.Expect(err.Error(), Equals, "failed")
+			c.Expect(mysqlTx.CommitWasCalled, IsFalse)
+			c.Expect(mysqlTx.RollbackWasCalled, IsTrue)
+
+			c.Specify("and returns both errors if the rollback fails", func() {
+				mysqlTx.RollbackFunc = func() error { return errors.New("rollback failed") }
+
+				_, err := db.InTx(func(Transaction) (interface{}, error) {
+					return nil, errors.New("failed")
+				})
+				rollbackErr, isRollbackErr := err.(RollbackError)
+				c.Assume(isRollbackErr, IsTrue)
+				c.Expect(rollbackErr.err.Error(), Equals, "rollback failed")
+				c.Expect(rollbackErr.triggeredBy.Error(), Equals, "failed")
+			})
+		})
+
+		c.Specify("is rolled back once if a statement fails", func() {
+			rollbacks := 0
+			mysqlTx.RollbackFunc = func() error {
+				rollbacks++
+				return nil
+			}
+
+			stmt := &MockStmt{
+				RunFunc: func(...interface{}) (mysql.Result, error) {
+					return nil, errors.New("run failed")
+				},
+			}
+
+			_, err := db.InTx(func(tx Transaction) (interface{}, error) {
+				return tx.Run(stmt)
+			})
+			c.Expect(err.Error(), Equals, "run failed")
+			c.Expect(rollbacks, Equals, 1)
+		})
+
+		c.Specify("fails if a statement failed even though it succeeds", func() {
+			runErr := errors.New("run failed")
+			stmt := &MockStmt{
+				RunFunc: func(...interface{}) (mysql.Result, error) {
+					return nil, runErr
+				},
+			}
+
+			res, err := db.InTx(func(tx Transaction) (interface{}, error) {
+				tx.Run(stmt)
+				return "ok", nil
+			})
+			c.Expect(err, Equals, runErr)
+			c.Expect(res, IsNil)
+			c.Expect(mysqlTx.CommitWasCalled, IsFalse)
+			c.Expect(mysqlTx.RollbackWasCalled, IsTrue)
+		})
+
+		c.Specify("fails if it rolls the transaction back itself", func() {
+			_, err := db.InTx(func(tx Transaction) (interface{}, error) {
+				return "ok", tx.Rollback()
+			})
+			c.Expect(err, Equals, ErrTxRolledBack)
+		})
+
+		c.Specify("is rolled back and panics again if it panics", func() {
+			var recovered interface{}
+			func() {
+				defer func() { recovered = recover() }()
+				db.InTx(func(Transaction) (interface{}, error) {
+					panic("closure panicked")
+				})
+			}()
+
+			c.Expect(recovered, Equals, "closure panicked")
+			c.Expect(mysqlTx.RollbackWasCalled, IsTrue)
+			c.Expect(mysqlTx.CommitWasCalled, IsFalse)
+		})
+
+		c.Specify("may commit the transaction itself", func() {
+			commits := 0
+			mysqlTx.CommitFunc = func() error {
+				commits++
+				return nil
+			}
+
+			_, err := db.InTx(func(tx Transaction) (interface{}, error) {
+				return nil, tx.Commit()
+			})
+			c.Expect(err, IsNil)
+			c.Expect(commits, Equals, 1)
 		})
 	})
+
+	c.Specify("a finished transaction", func() {
+		tx := newTransaction(&MockMysqlTx{}, NewMemBlobStore())
+		c.Assume(tx.Commit(), IsNil)
+
+		c.Specify("can't be committed or rolled back again", func() {
+			c.Expect(tx.Commit(), Equals, ErrTxDone)
+			c.Expect(tx.Rollback(), Equals, ErrTxDone)
+		})
+
+		c.Specify("can't run statements or save files", func() {
+			_, err := tx.Run(&MockStmt{})
+			c.Expect(err, Equals, ErrTxDone)
+			c.Expect(tx.tx.(*MockMysqlTx).DoWasCalled, IsFalse)
+
+			_, err = tx.SaveFile(datatype.FormFile{File: strings.NewReader("late")})
+			c.Expect(err, Equals, ErrTxDone)
+
+			_, err = tx.SaveFiles([]datatype.FormFile{{File: strings.NewReader("late")}})
+			c.Expect(err, Equals, ErrTxDone)
+
+			c.Expect(tx.ReleaseFile("name"), Equals, ErrTxDone)
+		})
+	})
+}
+
+// Cancels the context after the file is read for the first time
+// Fails to delete every blob
+type undeletableBlobStore struct {
+	BlobStore
+}
+
+func (undeletableBlobStore) Delete(string) error { return errors.New("delete failed") }
+
+type cancellingFile struct {
+	datatype.UploadedTempFile
+	cancel context.CancelFunc
+}
+
+func (f *cancellingFile) Read(p []byte) (int, error) {
+	defer f.cancel()
+	return f.UploadedTempFile.Read(p)
 }